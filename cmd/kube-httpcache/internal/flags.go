@@ -2,11 +2,25 @@ package internal
 
 import (
 	"flag"
+	"fmt"
+	"strings"
 	"time"
 
-	"github.com/golang/glog"
+	"github.com/mittwald/kube-httpcache/pkg/logging"
 )
 
+// stringList is a flag that can be given multiple times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 type KubeHTTPProxyFlags struct {
 	Kubernetes struct {
 		Config             string
@@ -20,6 +34,7 @@ type KubeHTTPProxyFlags struct {
 		Namespace string
 		Service   string
 		PortName  string
+		Listeners stringList
 	}
 	Backend struct {
 		Watch     bool
@@ -29,13 +44,48 @@ type KubeHTTPProxyFlags struct {
 		PortName  string
 	}
 	Signaller struct {
-		Enable             bool
-		Address            string
-		Port               int
-		WorkersCount       int
-		MaxRetries         int
-		RetryBackoffString string
-		RetryBackoff       time.Duration
+		Enable                bool
+		Address               string
+		Port                  int
+		WorkersCount          int
+		MaxRetries            int
+		RetryBackoffString    string
+		RetryBackoff          time.Duration
+		MaxRetryBackoffString string
+		MaxRetryBackoff       time.Duration
+		BreakerThreshold      int
+		BreakerCooldownString string
+		BreakerCooldown       time.Duration
+		ReplayTTLString       string
+		ReplayTTL             time.Duration
+		DedupWindowString     string
+		DedupWindow           time.Duration
+		BatchIntervalString   string
+		BatchInterval         time.Duration
+		Mode                  string
+		TokenFile             string
+		TokenReview           bool
+		TLSCertFile           string
+		TLSKeyFile            string
+		ClientCAFile          string
+		PolicyFile            string
+		EndpointScheme        string
+		EndpointCAFile        string
+		EndpointCertFile      string
+		EndpointKeyFile       string
+		EndpointServerName    string
+		QueueCapacity         int
+		QueueOverflow         string
+		QueueJournal          string
+		TagHeader             string
+		RateLimit             float64
+		RateBurst             int
+		ClientRateLimit       float64
+		ClientRateBurst       int
+		Routing               string
+		RoutingReplicas       int
+		LeaderElection        bool
+		LeaderElectionLease   string
 	}
 	Admin struct {
 		Address string
@@ -44,15 +94,42 @@ type KubeHTTPProxyFlags struct {
 	Varnish struct {
 		SecretFile           string
 		Storage              string
+		Storages             stringList
 		AdditionalParameters string
 		VCLTemplate          string
 		VCLTemplatePoll      bool
+		VCLFile              string
+		VCLArchiveDir        string
+		VCLArchiveSize       int
+		VCLDiffMaxLines      int
+		ParamsFile           string
+		AutoSize             bool
+		AutoSizeOverhead     float64
 		WorkingDir           string
+		External             bool
 	}
 	Readiness struct {
 		Enable  bool
 		Address string
 	}
+	Events struct {
+		Enable  bool
+		Service bool
+	}
+	DryRun struct {
+		Enable bool
+		Diff   bool
+		Dir    string
+	}
+	API struct {
+		Enable  bool
+		Address string
+	}
+	Log struct {
+		Format      string
+		LevelString string
+		Level       logging.Level
+	}
 }
 
 func (f *KubeHTTPProxyFlags) Parse() error {
@@ -63,6 +140,7 @@ func (f *KubeHTTPProxyFlags) Parse() error {
 
 	flag.StringVar(&f.Frontend.Address, "frontend-addr", "0.0.0.0", "TCP address to listen on")
 	flag.IntVar(&f.Frontend.Port, "frontend-port", 80, "TCP port to listen on")
+	flag.Var(&f.Frontend.Listeners, "frontend-listen", "additional named listener in the syntax of varnishd's -a flag, like 'proxy=0.0.0.0:8443,PROXY' or 'tls=/var/run/varnish.sock,PROXY,mode=0660' (can be given multiple times)")
 
 	flag.BoolVar(&f.Frontend.Watch, "frontend-watch", false, "watch for Kubernetes frontend updates")
 	flag.StringVar(&f.Frontend.Namespace, "frontend-namespace", "", "name of Kubernetes frontend namespace")
@@ -81,27 +159,88 @@ func (f *KubeHTTPProxyFlags) Parse() error {
 	flag.IntVar(&f.Signaller.WorkersCount, "signaller-workers", 1, "number of workers to process requests")
 	flag.IntVar(&f.Signaller.MaxRetries, "signaller-retries", 5, "maximum number of attempts for signalling request")
 	flag.StringVar(&f.Signaller.RetryBackoffString, "signaller-backoff", "30s", "backoff for signalling request attempts")
+	flag.StringVar(&f.Signaller.MaxRetryBackoffString, "signaller-backoff-max", "5m", "maximum backoff for signalling request attempts (the backoff doubles with every attempt)")
+	flag.IntVar(&f.Signaller.BreakerThreshold, "signaller-breaker-threshold", 5, "number of consecutive failures after which signals to an endpoint are paused (0 to disable)")
+	flag.StringVar(&f.Signaller.BreakerCooldownString, "signaller-breaker-cooldown", "30s", "duration for which signals to a failing endpoint are paused")
+	flag.StringVar(&f.Signaller.TokenFile, "signaller-token-file", "", "CSV file with bearer tokens ('token,caller') that are accepted by the signaller")
+	flag.BoolVar(&f.Signaller.TokenReview, "signaller-tokenreview", false, "authenticate signaller callers by their Kubernetes service account token")
+	flag.StringVar(&f.Signaller.TLSCertFile, "signaller-tls-cert", "", "TLS certificate file for the signaller")
+	flag.StringVar(&f.Signaller.TLSKeyFile, "signaller-tls-key", "", "TLS private key file for the signaller")
+	flag.StringVar(&f.Signaller.ClientCAFile, "signaller-client-ca", "", "CA bundle for authenticating signaller callers by TLS client certificates")
+	flag.StringVar(&f.Signaller.PolicyFile, "signaller-policy-file", "", "YAML file with the methods and path prefixes that authenticated signaller callers may use")
+	flag.StringVar(&f.Signaller.EndpointScheme, "signaller-endpoint-scheme", "http", "scheme used for broadcasting signals to the frontends ('http' or 'https')")
+	flag.StringVar(&f.Signaller.EndpointCAFile, "signaller-endpoint-ca", "", "CA bundle for verifying the frontends when broadcasting via HTTPS")
+	flag.StringVar(&f.Signaller.EndpointCertFile, "signaller-endpoint-cert", "", "TLS client certificate file for broadcasting via HTTPS")
+	flag.StringVar(&f.Signaller.EndpointKeyFile, "signaller-endpoint-key", "", "TLS client private key file for broadcasting via HTTPS")
+	flag.StringVar(&f.Signaller.EndpointServerName, "signaller-endpoint-server-name", "", "server name expected in the frontends' certificates when broadcasting via HTTPS")
+	flag.StringVar(&f.Signaller.ReplayTTLString, "signaller-replay-ttl", "0s", "duration for which signals are replayed to frontends that appear after the signal was received (0 to disable)")
+	flag.StringVar(&f.Signaller.DedupWindowString, "signaller-dedup-window", "0s", "duration within which identical signal requests are only broadcast once (0 to disable)")
+	flag.StringVar(&f.Signaller.BatchIntervalString, "signaller-batch-interval", "0s", "interval in which bans are merged into combined bans before broadcasting them; only in admin mode (0 to disable)")
+	flag.IntVar(&f.Signaller.QueueCapacity, "signaller-queue-capacity", 10000, "maximum number of queued signals (0 for unbounded)")
+	flag.StringVar(&f.Signaller.QueueOverflow, "signaller-queue-overflow", "reject", "behaviour when the signal queue is full; 'reject' responds with 503, 'drop-oldest' discards the oldest queued signals")
+	flag.StringVar(&f.Signaller.QueueJournal, "signaller-queue-journal", "", "file for persisting queued signals across restarts (for example, in the Varnish working directory)")
+	flag.StringVar(&f.Signaller.TagHeader, "signaller-tag-header", "xkey", "header that contains the cache tags (surrogate keys) of objects, used by the invalidation API")
+	flag.Float64Var(&f.Signaller.RateLimit, "signaller-rate-limit", 0, "maximum number of signal requests per second, across all clients (0 to disable)")
+	flag.IntVar(&f.Signaller.RateBurst, "signaller-rate-burst", 100, "number of signal requests that may exceed -signaller-rate-limit in a burst")
+	flag.Float64Var(&f.Signaller.ClientRateLimit, "signaller-client-rate-limit", 0, "maximum number of signal requests per second and client IP address (0 to disable)")
+	flag.IntVar(&f.Signaller.ClientRateBurst, "signaller-client-rate-burst", 20, "number of signal requests that may exceed -signaller-client-rate-limit in a burst")
+	flag.StringVar(&f.Signaller.Routing, "signaller-routing", "all", "frontends that PURGE requests are sent to; 'all' broadcasts to every frontend, 'hash' and 'shard' only to the frontend(s) that a 'directors.hash()' or 'directors.shard()' director in the VCL picks for the URL")
+	flag.IntVar(&f.Signaller.RoutingReplicas, "signaller-routing-replicas", 1, "number of frontends that own a URL with -signaller-routing=shard (the owner followed by its alternatives on the hash ring)")
+	flag.BoolVar(&f.Signaller.LeaderElection, "signaller-leader-election", false, "elect a leader among all signallers (using a Kubernetes Lease in the frontend namespace) that processes all signal requests; followers forward signal requests to the leader")
+	flag.StringVar(&f.Signaller.LeaderElectionLease, "signaller-leader-election-lease", "kube-httpcache-signaller", "name of the Lease used for electing the signaller leader")
+	flag.StringVar(&f.Signaller.Mode, "signaller-mode", "http", "how signals are delivered to the frontends; 'http' re-sends the request, 'admin' issues bans via the Varnish admin port")
 
-	flag.StringVar(&f.Admin.Address, "admin-addr", "127.0.0.1", "TCP address for the Varnish admin")
+	flag.StringVar(&f.Admin.Address, "admin-addr", "127.0.0.1", "TCP address for the Varnish admin (the address to connect to with -varnish-external)")
 	flag.IntVar(&f.Admin.Port, "admin-port", 6082, "TCP port for the Varnish admin")
 
 	flag.StringVar(&f.Varnish.SecretFile, "varnish-secret-file", "/etc/varnish/secret", "Varnish secret file")
 	flag.StringVar(&f.Varnish.Storage, "varnish-storage", "file,/tmp/varnish-data,1G", "varnish storage config")
+	flag.Var(&f.Varnish.Storages, "varnish-additional-storage", "additional named storage in the syntax of varnishd's -s flag, like 'memory=malloc,4G' or 'disk=file,/data/cache,50G' (can be given multiple times)")
 	flag.StringVar(&f.Varnish.VCLTemplate, "varnish-vcl-template", "/etc/varnish/default.vcl.tmpl", "VCL template file")
 	flag.StringVar(&f.Varnish.AdditionalParameters, "varnish-additional-parameters", "", "Additional Varnish start parameters (-p, seperated by comma), like 'ban_dups=on,cli_timeout=30'")
+	flag.StringVar(&f.Varnish.VCLFile, "varnish-vcl-file", "/tmp/vcl", "file that the active VCL is written to (and that varnishd is started with)")
+	flag.StringVar(&f.Varnish.VCLArchiveDir, "varnish-vcl-archive-dir", "", "directory for archiving rendered VCLs (named after their reload) for debugging; disabled if empty")
+	flag.IntVar(&f.Varnish.VCLArchiveSize, "varnish-vcl-archive-size", 10, "number of rendered VCLs to keep in -varnish-vcl-archive-dir")
+	flag.IntVar(&f.Varnish.VCLDiffMaxLines, "varnish-vcl-diff-lines", 100, "maximum number of lines of the VCL diff that is logged for every reload (0 to disable)")
+	flag.StringVar(&f.Varnish.ParamsFile, "varnish-params-file", "", "YAML or JSON file with varnishd parameters (like 'thread_pool_max: 5000'); the file is watched and changes are applied at runtime")
+	flag.BoolVar(&f.Varnish.AutoSize, "varnish-auto-size", false, "derive a malloc storage size, thread_pools and thread_pool_max from the container's cgroup memory and CPU limits (overrides the size of -varnish-storage)")
+	flag.Float64Var(&f.Varnish.AutoSizeOverhead, "varnish-auto-size-overhead", 0.25, "fraction of the memory limit that is not used for the storage with -varnish-auto-size (for worker threads, transient storage and other overhead)")
 	flag.BoolVar(&f.Varnish.VCLTemplatePoll, "varnish-vcl-template-poll", false, "poll for file changes instead of using inotify (useful on some network filesystems)")
 	flag.StringVar(&f.Varnish.WorkingDir, "varnish-working-dir", "", "varnish working directory (-n)")
+	flag.BoolVar(&f.Varnish.External, "varnish-external", false, "do not start varnishd; instead, push the VCL to an already running varnishd (like a separate container in the same pod) via its admin port at -admin-addr and -admin-port")
 
 	// present for BC only; no effect until #36 [1] has resolved
 	//   [1]: https://github.com/mittwald/kube-httpcache/issues/36
 	flag.BoolVar(&f.Readiness.Enable, "readiness-enable", true, "enable readiness probe")
 	flag.StringVar(&f.Readiness.Address, "readiness-addr", "0.0.0.0:9102", "address for the readiness probe to listen on")
 
+	flag.BoolVar(&f.Events.Enable, "events-enable", false, "emit Kubernetes events about VCL reloads and Varnish restarts on the controller's pod (which needs to run in the frontend namespace)")
+	flag.BoolVar(&f.Events.Service, "events-service", false, "additionally emit Kubernetes events on the frontend service")
+
+	flag.BoolVar(&f.DryRun.Enable, "dry-run", false, "watch the template, frontends and backends, but only write every rendered VCL (and the endpoints it was rendered for) instead of starting Varnish; disables the signaller and events")
+	flag.BoolVar(&f.DryRun.Diff, "dry-run-diff", false, "in dry-run mode, write unified diffs against the previously rendered VCL instead of full VCLs")
+	flag.StringVar(&f.DryRun.Dir, "dry-run-dir", "", "directory to write rendered VCLs to in dry-run mode (stdout if empty)")
+
+	flag.BoolVar(&f.API.Enable, "api-enable", false, "serve a read-only HTTP API for inspecting the controller state (endpoints, active VCL, reload history and signal queue)")
+	flag.StringVar(&f.API.Address, "api-addr", "127.0.0.1:9103", "address for the controller API to listen on")
+
+	flag.StringVar(&f.Log.Format, "log-format", "text", "log output format ('text' or 'json')")
+	flag.StringVar(&f.Log.LevelString, "log-level", "info", "minimum level of log entries ('debug', 'info', 'warning' or 'error')")
+
 	flag.Parse()
 
+	f.Log.Level, err = logging.ParseLevel(f.Log.LevelString)
+	if err != nil {
+		return err
+	}
+
+	if err := logging.Configure(f.Log.Format, f.Log.Level); err != nil {
+		return err
+	}
+
 	if len(f.Backend.Port) > 0 {
 		f.Backend.PortName = f.Backend.Port
-		glog.Warningf("-backend-port flag has been deprecated in favor of -backend-portname and will be removed in future versions")
+		logging.New("main").Warning("-backend-port flag has been deprecated in favor of -backend-portname and will be removed in future versions")
 	}
 
 	f.Kubernetes.RetryBackoff, err = time.ParseDuration(f.Kubernetes.RetryBackoffString)
@@ -114,5 +253,97 @@ func (f *KubeHTTPProxyFlags) Parse() error {
 		return err
 	}
 
+	f.Signaller.MaxRetryBackoff, err = time.ParseDuration(f.Signaller.MaxRetryBackoffString)
+	if err != nil {
+		return err
+	}
+
+	f.Signaller.BreakerCooldown, err = time.ParseDuration(f.Signaller.BreakerCooldownString)
+	if err != nil {
+		return err
+	}
+
+	f.Signaller.ReplayTTL, err = time.ParseDuration(f.Signaller.ReplayTTLString)
+	if err != nil {
+		return err
+	}
+
+	f.Signaller.DedupWindow, err = time.ParseDuration(f.Signaller.DedupWindowString)
+	if err != nil {
+		return err
+	}
+
+	f.Signaller.BatchInterval, err = time.ParseDuration(f.Signaller.BatchIntervalString)
+	if err != nil {
+		return err
+	}
+
+	if (f.Signaller.TLSCertFile == "") != (f.Signaller.TLSKeyFile == "") {
+		return fmt.Errorf("-signaller-tls-cert and -signaller-tls-key must be used together")
+	}
+
+	if f.Signaller.ClientCAFile != "" && f.Signaller.TLSCertFile == "" {
+		return fmt.Errorf("-signaller-client-ca requires -signaller-tls-cert and -signaller-tls-key")
+	}
+
+	if f.Signaller.EndpointScheme != "http" && f.Signaller.EndpointScheme != "https" {
+		return fmt.Errorf("invalid signaller endpoint scheme '%s'; expected 'http' or 'https'", f.Signaller.EndpointScheme)
+	}
+
+	if (f.Signaller.EndpointCertFile == "") != (f.Signaller.EndpointKeyFile == "") {
+		return fmt.Errorf("-signaller-endpoint-cert and -signaller-endpoint-key must be used together")
+	}
+
+	if f.Signaller.QueueOverflow != "reject" && f.Signaller.QueueOverflow != "drop-oldest" {
+		return fmt.Errorf("invalid signaller queue overflow policy '%s'; expected 'reject' or 'drop-oldest'", f.Signaller.QueueOverflow)
+	}
+
+	if f.Signaller.RateLimit < 0 || f.Signaller.ClientRateLimit < 0 {
+		return fmt.Errorf("signaller rate limits must not be negative")
+	}
+
+	if (f.Signaller.RateLimit > 0 && f.Signaller.RateBurst < 1) || (f.Signaller.ClientRateLimit > 0 && f.Signaller.ClientRateBurst < 1) {
+		return fmt.Errorf("signaller rate limit bursts must be at least 1")
+	}
+
+	if f.Signaller.Routing != "all" && f.Signaller.Routing != "hash" && f.Signaller.Routing != "shard" {
+		return fmt.Errorf("invalid signaller routing '%s'; expected 'all', 'hash' or 'shard'", f.Signaller.Routing)
+	}
+
+	if f.Signaller.RoutingReplicas < 1 {
+		return fmt.Errorf("-signaller-routing-replicas must be at least 1")
+	}
+
+	if f.Signaller.LeaderElection && !f.Frontend.Watch {
+		return fmt.Errorf("-signaller-leader-election requires -frontend-watch")
+	}
+
+	if f.Events.Service && (!f.Events.Enable || f.Frontend.Service == "") {
+		return fmt.Errorf("-events-service requires -events-enable and -frontend-service")
+	}
+
+	if f.Signaller.Mode != "http" && f.Signaller.Mode != "admin" {
+		return fmt.Errorf("invalid signaller mode '%s'; expected 'http' or 'admin'", f.Signaller.Mode)
+	}
+
+	if f.Varnish.AutoSizeOverhead <= 0 || f.Varnish.AutoSizeOverhead >= 1 {
+		return fmt.Errorf("-varnish-auto-size-overhead must be between 0 and 1")
+	}
+
+	if f.Varnish.AutoSize && f.Varnish.External {
+		return fmt.Errorf("-varnish-auto-size cannot be used with -varnish-external")
+	}
+
+	if f.DryRun.Enable && f.Varnish.External {
+		return fmt.Errorf("-dry-run and -varnish-external cannot be used together")
+	}
+
+	if f.DryRun.Enable && (f.Signaller.Enable || f.Events.Enable) {
+		logging.New("main").Warning("the signaller and events are disabled in dry-run mode")
+
+		f.Signaller.Enable = false
+		f.Events.Enable = false
+	}
+
 	return nil
 }
@@ -3,13 +3,19 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
-	"github.com/golang/glog"
 	"github.com/mittwald/kube-httpcache/cmd/kube-httpcache/internal"
 	"github.com/mittwald/kube-httpcache/pkg/controller"
+	"github.com/mittwald/kube-httpcache/pkg/logging"
 	"github.com/mittwald/kube-httpcache/pkg/signaller"
 	"github.com/mittwald/kube-httpcache/pkg/watcher"
 	"k8s.io/client-go/kubernetes"
@@ -19,8 +25,16 @@ import (
 
 var opts internal.KubeHTTPProxyFlags
 
+// serviceAccountNamespaceFile contains the namespace of the pod, if a service
+// account token is mounted
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+var logger = logging.New("main")
+
 func init() {
-	flag.Set("logtostderr", "true") // enable sending log to stderr
+	// kube-httpcache logs via pkg/logging; this only affects the glog output
+	// of the Kubernetes client library
+	flag.Set("logtostderr", "true")
 }
 
 func main() {
@@ -28,17 +42,33 @@ func main() {
 		panic(err) // abort the main prcoess if error
 	}
 
-	glog.Infof("running kube-httpcache with following options: %+v", opts)
+	logger.Info("running kube-httpcache",
+		"frontend_address", net.JoinHostPort(opts.Frontend.Address, strconv.Itoa(opts.Frontend.Port)),
+		"frontend_watch", opts.Frontend.Watch,
+		"frontend_service", opts.Frontend.Namespace+"/"+opts.Frontend.Service,
+		"backend_watch", opts.Backend.Watch,
+		"backend_service", opts.Backend.Namespace+"/"+opts.Backend.Service,
+		"backend_portname", opts.Backend.PortName,
+		"signaller", opts.Signaller.Enable,
+		"signaller_mode", opts.Signaller.Mode,
+		"signaller_address", net.JoinHostPort(opts.Signaller.Address, strconv.Itoa(opts.Signaller.Port)),
+		"leader_election", opts.Signaller.LeaderElection,
+		"admin_address", net.JoinHostPort(opts.Admin.Address, strconv.Itoa(opts.Admin.Port)),
+		"vcl_template", opts.Varnish.VCLTemplate,
+		"external_varnish", opts.Varnish.External,
+		"dry_run", opts.DryRun.Enable,
+		"log_level", opts.Log.LevelString,
+	)
 
 	var config *rest.Config // Config holds the common attributes that can be passed to a Kubernetes client on initialization.
 	var err error
 	var client kubernetes.Interface
 
 	if opts.Kubernetes.Config == "" {
-		glog.Infof("using in-cluster configuration")
+		logger.Info("using in-cluster configuration")
 		config, err = rest.InClusterConfig() // ServiceAccount of pod
 	} else {
-		glog.Infof("using configuration from '%s'", opts.Kubernetes.Config)
+		logger.Info("using configuration from file", "file", opts.Kubernetes.Config)
 		config, err = clientcmd.BuildConfigFromFlags("", opts.Kubernetes.Config) // build kube config outta opts (pre-defined)
 	}
 
@@ -74,6 +104,30 @@ func main() {
 		backendUpdates, backendErrors = backendWatcher.Run()
 	}
 
+	var secretUpdates chan []byte
+	var secretErrors chan error
+	if !opts.DryRun.Enable { // the admin secret is not needed in dry-run mode
+		if opts.Varnish.External {
+			// an external varnishd has been started with its own secret,
+			// so a generated secret could never be used for authenticating
+			if _, err := os.Stat(opts.Varnish.SecretFile); err != nil {
+				panic(fmt.Errorf("the admin secret file of the external varnishd is not available: %s", err.Error()))
+			}
+		} else {
+			generated, err := controller.EnsureSecretFile(opts.Varnish.SecretFile)
+			if err != nil {
+				panic(err)
+			}
+
+			if generated {
+				logger.Info("generated random admin secret", "file", opts.Varnish.SecretFile)
+			}
+		}
+
+		secretWatcher := watcher.MustNewTemplateWatcher(opts.Varnish.SecretFile, opts.Varnish.VCLTemplatePoll) // same machinery as for the template, for rotating the admin secret
+		secretUpdates, secretErrors = secretWatcher.Run()
+	}
+
 	templateWatcher := watcher.MustNewTemplateWatcher(opts.Varnish.VCLTemplate, opts.Varnish.VCLTemplatePoll) // if polling is true, pulls the new vcl config
 	templateUpdates, templateErrors := templateWatcher.Run()                                                  // init watch loop
 
@@ -86,7 +140,83 @@ func main() {
 			opts.Signaller.WorkersCount,
 			opts.Signaller.MaxRetries,
 			opts.Signaller.RetryBackoff,
+			opts.Signaller.QueueCapacity,
+			opts.Signaller.QueueOverflow,
+			opts.Signaller.BreakerThreshold,
+			opts.Signaller.BreakerCooldown,
+			opts.Signaller.ReplayTTL,
+			opts.Signaller.DedupWindow,
+			opts.Signaller.BatchInterval,
 		)
+		varnishSignaller.MaxRetryBackoff = opts.Signaller.MaxRetryBackoff
+		varnishSignaller.Mode = opts.Signaller.Mode
+		varnishSignaller.AdminPort = opts.Admin.Port
+		varnishSignaller.TLSCertFile = opts.Signaller.TLSCertFile
+		varnishSignaller.TLSKeyFile = opts.Signaller.TLSKeyFile
+		varnishSignaller.ClientCAFile = opts.Signaller.ClientCAFile
+		varnishSignaller.EndpointScheme = opts.Signaller.EndpointScheme
+		varnishSignaller.EndpointCAFile = opts.Signaller.EndpointCAFile
+		varnishSignaller.EndpointCertFile = opts.Signaller.EndpointCertFile
+		varnishSignaller.EndpointKeyFile = opts.Signaller.EndpointKeyFile
+		varnishSignaller.EndpointServerName = opts.Signaller.EndpointServerName
+		varnishSignaller.JournalFile = opts.Signaller.QueueJournal
+		varnishSignaller.TagHeader = opts.Signaller.TagHeader
+		varnishSignaller.Routing = opts.Signaller.Routing
+		varnishSignaller.RoutingReplicas = opts.Signaller.RoutingReplicas
+
+		if opts.Signaller.RateLimit > 0 || opts.Signaller.ClientRateLimit > 0 {
+			varnishSignaller.RateLimiter = signaller.NewRateLimiter(
+				opts.Signaller.RateLimit,
+				opts.Signaller.RateBurst,
+				opts.Signaller.ClientRateLimit,
+				opts.Signaller.ClientRateBurst,
+			)
+		}
+
+		if opts.Signaller.ClientCAFile != "" {
+			varnishSignaller.Authenticators = append(varnishSignaller.Authenticators, signaller.ClientCertAuthenticator{})
+		}
+
+		if opts.Signaller.TokenFile != "" {
+			tokenAuthenticator, err := signaller.NewTokenFileAuthenticator(opts.Signaller.TokenFile)
+			if err != nil {
+				panic(err)
+			}
+
+			varnishSignaller.Authenticators = append(varnishSignaller.Authenticators, tokenAuthenticator)
+		}
+
+		if opts.Signaller.TokenReview {
+			varnishSignaller.Authenticators = append(varnishSignaller.Authenticators, signaller.NewTokenReviewAuthenticator(client, time.Minute))
+		}
+
+		if opts.Signaller.PolicyFile != "" {
+			varnishSignaller.Policy, err = signaller.LoadPolicy(opts.Signaller.PolicyFile)
+			if err != nil {
+				panic(err)
+			}
+		}
+
+		if opts.Signaller.Mode == signaller.ModeAdmin {
+			secret, err := ioutil.ReadFile(opts.Varnish.SecretFile)
+			if err != nil {
+				panic(err)
+			}
+
+			varnishSignaller.SetAdminSecret(secret)
+		}
+
+		// the election is enabled before the signaller starts listening, so
+		// that no request is processed before the leader is known
+		if opts.Signaller.LeaderElection {
+			identity, err := os.Hostname()
+			if err != nil {
+				panic(err)
+			}
+
+			varnishSignaller.EnableLeaderElection(identity)
+		}
+
 		varnishSignallerErrors = varnishSignaller.GetErrors()
 
 		go func() { // Not sure why is it running as a go routine here
@@ -101,13 +231,15 @@ func main() {
 		for {
 			select { // fan in pattern, if error pops up from any of process, put it in a stderr
 			case err := <-frontendErrors:
-				glog.Errorf("error while watching frontends: %s", err.Error())
+				logger.Error("error while watching frontends", "service", "frontend", "error", err)
 			case err := <-backendErrors:
-				glog.Errorf("error while watching backends: %s", err.Error())
+				logger.Error("error while watching backends", "service", "backend", "error", err)
 			case err := <-templateErrors:
-				glog.Errorf("error while watching template changes: %s", err.Error())
+				logger.Error("error while watching template changes", "error", err)
+			case err := <-secretErrors:
+				logger.Error("error while watching admin secret changes", "error", err)
 			case err := <-varnishSignallerErrors:
-				glog.Errorf("error while running varnish signaller: %s", err.Error())
+				logger.Error("error while running varnish signaller", "error", err)
 			}
 		}
 	}()
@@ -125,6 +257,7 @@ func main() {
 		frontendUpdates,
 		backendUpdates,
 		templateUpdates,
+		secretUpdates,
 		varnishSignaller,
 		opts.Varnish.VCLTemplate,
 	)
@@ -132,6 +265,78 @@ func main() {
 		panic(err)
 	}
 
+	varnishController.ExternalVarnish = opts.Varnish.External
+
+	varnishController.Listeners, err = controller.ParseListeners(opts.Frontend.Listeners)
+	if err != nil {
+		panic(err)
+	}
+
+	varnishController.Storages, err = controller.ParseStorages(opts.Varnish.Storage, opts.Varnish.Storages)
+	if err != nil {
+		panic(err)
+	}
+
+	if opts.Varnish.AutoSize {
+		if err := controller.CheckAutoSizeStorages(varnishController.Storages); err != nil {
+			panic(err)
+		}
+	}
+
+	varnishController.DryRun = opts.DryRun.Enable
+	varnishController.DryRunDiff = opts.DryRun.Diff
+	varnishController.DryRunDir = opts.DryRun.Dir
+	varnishController.VCLFile = opts.Varnish.VCLFile
+	varnishController.VCLArchiveDir = opts.Varnish.VCLArchiveDir
+	varnishController.VCLArchiveSize = opts.Varnish.VCLArchiveSize
+	varnishController.VCLDiffMaxLines = opts.Varnish.VCLDiffMaxLines
+	varnishController.AutoSize = opts.Varnish.AutoSize
+	varnishController.AutoSizeOverhead = opts.Varnish.AutoSizeOverhead
+
+	if opts.Varnish.ParamsFile != "" && !opts.DryRun.Enable {
+		paramsWatcher := watcher.MustNewTemplateWatcher(opts.Varnish.ParamsFile, opts.Varnish.VCLTemplatePoll)
+		paramsUpdates, paramsErrors := paramsWatcher.Run()
+
+		varnishController.ParamsFile = opts.Varnish.ParamsFile
+		varnishController.ParamsUpdates = paramsUpdates
+
+		go func() {
+			for err := range paramsErrors {
+				logger.Error("error while watching parameters file changes", "file", opts.Varnish.ParamsFile, "error", err)
+			}
+		}()
+	}
+
+	if opts.Events.Enable {
+		podName, err := os.Hostname()
+		if err != nil {
+			panic(err)
+		}
+
+		serviceName := ""
+		if opts.Events.Service {
+			serviceName = opts.Frontend.Service
+		}
+
+		podNamespace, err := podNamespace()
+		if err != nil {
+			panic(err)
+		}
+
+		varnishController.Events, err = controller.NewEventRecorder(client, podNamespace, podName, opts.Frontend.Namespace, serviceName)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if opts.API.Enable {
+		go func() {
+			if err := varnishController.ServeAPI(opts.API.Address); err != nil {
+				panic(err)
+			}
+		}()
+	}
+
 	ctx, cancel := context.WithCancel(context.Background()) // WithCancel returns a copy of parent with a new Done channel. The returned context's Done channel is closed when the returned cancel function is called or when the parent context's Done channel is closed, whichever happens first.
 
 	signals := make(chan os.Signal, 1) // channel to get os level signal(like SIGTERM) with buffer size 1
@@ -139,10 +344,14 @@ func main() {
 	signal.Notify(signals, syscall.SIGINT) // signal.Notify registers the given channel to receive notifications of the specified signals
 	signal.Notify(signals, syscall.SIGTERM)
 
+	if varnishSignaller != nil && opts.Signaller.LeaderElection {
+		go varnishSignaller.RunLeaderElection(ctx, client, opts.Frontend.Namespace, opts.Signaller.LeaderElectionLease)
+	}
+
 	go func() {
 		s := <-signals
 
-		glog.Infof("received signal %s", s) // whenever the channel get the os signal, goroutine prints out which signal it got to stdout
+		logger.Info("received signal", "signal", s) // whenever the channel get the os signal, goroutine prints out which signal it got to stdout
 		cancel()
 	}()
 
@@ -151,3 +360,19 @@ func main() {
 		panic(err)
 	}
 }
+
+// podNamespace returns the namespace of the pod kube-httpcache is running in,
+// taken from the POD_NAMESPACE environment variable (which can be set using
+// the downward API) or the mounted service account
+func podNamespace() (string, error) {
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		return namespace, nil
+	}
+
+	namespace, err := ioutil.ReadFile(serviceAccountNamespaceFile)
+	if err != nil {
+		return "", fmt.Errorf("could not determine the namespace of the pod; set POD_NAMESPACE: %s", err.Error())
+	}
+
+	return strings.TrimSpace(string(namespace)), nil
+}
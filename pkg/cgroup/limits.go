@@ -0,0 +1,142 @@
+package cgroup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultRoot is the mount point of the cgroup filesystem
+const DefaultRoot = "/sys/fs/cgroup"
+
+// unlimitedMemory is the threshold above which a cgroup v1 memory limit is
+// considered unset (the kernel reports a page-aligned variant of MaxInt64)
+const unlimitedMemory = 1 << 62
+
+// Limits contains the resource limits of the cgroup that the current
+// process runs in. Zero values mean that there is no limit.
+type Limits struct {
+	Version int
+	Memory  int64
+	CPUs    float64
+}
+
+// Read determines the memory and CPU limits from the cgroup filesystem
+// mounted at root, supporting both cgroup v1 and v2
+func Read(root string) (Limits, error) {
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		return readV2(root)
+	}
+
+	return readV1(root)
+}
+
+func readV2(root string) (Limits, error) {
+	l := Limits{Version: 2}
+
+	memory, err := readString(filepath.Join(root, "memory.max"))
+	if err != nil {
+		return l, err
+	}
+
+	if memory != "" && memory != "max" {
+		if l.Memory, err = strconv.ParseInt(memory, 10, 64); err != nil {
+			return l, err
+		}
+	}
+
+	// cpu.max contains the quota and the period, like "200000 100000"
+	cpu, err := readString(filepath.Join(root, "cpu.max"))
+	if err != nil {
+		return l, err
+	}
+
+	if fields := strings.Fields(cpu); len(fields) == 2 && fields[0] != "max" {
+		l.CPUs, err = quotaCPUs(fields[0], fields[1])
+		if err != nil {
+			return l, err
+		}
+	}
+
+	return l, nil
+}
+
+func readV1(root string) (Limits, error) {
+	l := Limits{Version: 1}
+
+	memory, err := readString(filepath.Join(root, "memory", "memory.limit_in_bytes"))
+	if err != nil {
+		return l, err
+	}
+
+	if memory != "" {
+		limit, err := strconv.ParseInt(memory, 10, 64)
+		if err != nil {
+			return l, err
+		}
+
+		if limit < unlimitedMemory {
+			l.Memory = limit
+		}
+	}
+
+	for _, dir := range []string{"cpu", "cpu,cpuacct"} {
+		quota, err := readString(filepath.Join(root, dir, "cpu.cfs_quota_us"))
+		if err != nil {
+			return l, err
+		}
+
+		if quota == "" {
+			continue
+		}
+
+		if quota != "-1" {
+			period, err := readString(filepath.Join(root, dir, "cpu.cfs_period_us"))
+			if err != nil {
+				return l, err
+			}
+
+			if l.CPUs, err = quotaCPUs(quota, period); err != nil {
+				return l, err
+			}
+		}
+
+		break
+	}
+
+	return l, nil
+}
+
+func quotaCPUs(quota, period string) (float64, error) {
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if q <= 0 || p <= 0 {
+		return 0, nil
+	}
+
+	return q / p, nil
+}
+
+// readString reads a single-line file; missing files are treated as empty
+func readString(filename string) (string, error) {
+	contents, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(contents)), nil
+}
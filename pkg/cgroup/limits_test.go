@@ -0,0 +1,34 @@
+package cgroup
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		root   string
+		limits Limits
+		err    bool
+	}{
+		{"v1", Limits{Version: 1, Memory: 512 << 20, CPUs: 1.5}, false},
+		{"v1-cpuacct", Limits{Version: 1, Memory: 1 << 30, CPUs: 2}, false},
+		{"v1-unlimited", Limits{Version: 1}, false},
+		{"v2", Limits{Version: 2, Memory: 256 << 20, CPUs: 0.5}, false},
+		{"v2-unlimited", Limits{Version: 2}, false},
+		{"missing", Limits{Version: 1}, false},
+		{"invalid", Limits{}, true},
+	}
+
+	for _, test := range tests {
+		limits, err := Read(filepath.Join("testdata", test.root))
+		if (err != nil) != test.err {
+			t.Errorf("%s: unexpected error %v", test.root, err)
+			continue
+		}
+
+		if !test.err && limits != test.limits {
+			t.Errorf("%s: expected %+v, got %+v", test.root, test.limits, limits)
+		}
+	}
+}
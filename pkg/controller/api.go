@@ -0,0 +1,206 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/mittwald/kube-httpcache/pkg/watcher"
+)
+
+const (
+	ReloadTriggerInitial  = "initial"
+	ReloadTriggerTemplate = "template"
+	ReloadTriggerFrontend = "frontend"
+	ReloadTriggerBackend  = "backend"
+	ReloadTriggerRestart  = "restart"
+)
+
+// maxReloadRecords limits the number of reloads kept in the reload history
+const maxReloadRecords = 50
+
+// ReloadRecord describes a single attempt to reload the VCL
+type ReloadRecord struct {
+	Time     time.Time `json:"time"`
+	Trigger  string    `json:"trigger"`
+	VCLName  string    `json:"vclName,omitempty"`
+	Duration string    `json:"duration"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// VCLState describes the currently active VCL
+type VCLState struct {
+	Name         string `json:"name"`
+	TemplateHash string `json:"templateHash"`
+	Source       string `json:"source,omitempty"`
+}
+
+// State summarizes the state of the controller
+type State struct {
+	VCL        VCLState                `json:"vcl"`
+	Frontend   *watcher.EndpointConfig `json:"frontend"`
+	Backend    *watcher.EndpointConfig `json:"backend"`
+	LastReload *ReloadRecord           `json:"lastReload,omitempty"`
+	Signaller  bool                    `json:"signaller"`
+}
+
+func templateHash(contents []byte) string {
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:])
+}
+
+// reload rebuilds the VCL and records the result in the reload history
+func (v *VarnishController) reload(ctx context.Context, i int, trigger string) error {
+	start := time.Now()
+
+	var err error
+	if v.DryRun {
+		err = v.renderDryRun(i, trigger)
+	} else {
+		err = v.rebuildConfig(ctx, i, trigger)
+	}
+
+	name := ""
+	if err == nil {
+		v.stateMutex.RLock()
+		name = v.currentVCLName
+		v.stateMutex.RUnlock()
+	}
+
+	v.recordReload(trigger, name, start, err)
+	return err
+}
+
+func (v *VarnishController) recordReload(trigger, vclName string, start time.Time, err error) {
+	r := ReloadRecord{
+		Time:     start,
+		Trigger:  trigger,
+		VCLName:  vclName,
+		Duration: time.Since(start).String(),
+	}
+
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	v.stateMutex.Lock()
+	defer v.stateMutex.Unlock()
+
+	v.reloads = append(v.reloads, r)
+	if len(v.reloads) > maxReloadRecords {
+		v.reloads = v.reloads[len(v.reloads)-maxReloadRecords:]
+	}
+}
+
+func (v *VarnishController) vclState(withSource bool) VCLState {
+	s := VCLState{
+		Name:         v.currentVCLName,
+		TemplateHash: v.templateHash,
+	}
+
+	if withSource {
+		s.Source = string(v.currentVCL)
+	}
+
+	return s
+}
+
+// ServeAPI serves a read-only HTTP API for inspecting the controller state
+// (like the current endpoints, the active VCL and recent reloads) on the
+// given address. It blocks until the server fails.
+func (v *VarnishController) ServeAPI(address string) error {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/v1/state", func(w http.ResponseWriter, r *http.Request) {
+		v.stateMutex.RLock()
+		s := State{
+			VCL:       v.vclState(false),
+			Frontend:  v.frontend,
+			Backend:   v.backend,
+			Signaller: v.varnishSignaller != nil,
+		}
+
+		if len(v.reloads) > 0 {
+			last := v.reloads[len(v.reloads)-1]
+			s.LastReload = &last
+		}
+		v.stateMutex.RUnlock()
+
+		writeAPIResponse(w, r, s)
+	})
+
+	mux.HandleFunc("/api/v1/frontends", func(w http.ResponseWriter, r *http.Request) {
+		v.stateMutex.RLock()
+		frontend := v.frontend
+		v.stateMutex.RUnlock()
+
+		writeAPIResponse(w, r, frontend)
+	})
+
+	mux.HandleFunc("/api/v1/backends", func(w http.ResponseWriter, r *http.Request) {
+		v.stateMutex.RLock()
+		backend := v.backend
+		v.stateMutex.RUnlock()
+
+		writeAPIResponse(w, r, backend)
+	})
+
+	mux.HandleFunc("/api/v1/vcl", func(w http.ResponseWriter, r *http.Request) {
+		v.stateMutex.RLock()
+		s := v.vclState(true)
+		v.stateMutex.RUnlock()
+
+		writeAPIResponse(w, r, s)
+	})
+
+	mux.HandleFunc("/api/v1/reloads", func(w http.ResponseWriter, r *http.Request) {
+		v.stateMutex.RLock()
+		reloads := make([]ReloadRecord, len(v.reloads))
+		copy(reloads, v.reloads)
+		v.stateMutex.RUnlock()
+
+		writeAPIResponse(w, r, reloads)
+	})
+
+	mux.HandleFunc("/api/v1/params", func(w http.ResponseWriter, r *http.Request) {
+		v.stateMutex.RLock()
+		params := make([]ParamResult, len(v.params))
+		copy(params, v.params)
+		v.stateMutex.RUnlock()
+
+		writeAPIResponse(w, r, params)
+	})
+
+	mux.HandleFunc("/api/v1/signaller", func(w http.ResponseWriter, r *http.Request) {
+		if v.varnishSignaller == nil {
+			http.Error(w, "signaller is not enabled", http.StatusNotFound)
+			return
+		}
+
+		writeAPIResponse(w, r, v.varnishSignaller.State())
+	})
+
+	logger.Info("serving controller API", "address", address)
+
+	return http.ListenAndServe(address, mux)
+}
+
+func writeAPIResponse(w http.ResponseWriter, r *http.Request, body interface{}) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	if err := enc.Encode(body); err != nil {
+		logger.Warning("error while writing API response", "path", r.URL.Path, "error", err)
+	}
+}
@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/mittwald/kube-httpcache/pkg/cgroup"
+)
+
+const (
+	// autoSizeThreadMemory is the estimated memory used by a single worker
+	// thread (stack and workspaces)
+	autoSizeThreadMemory = 256 * 1024
+
+	// autoSizeMinStorage is the smallest storage size that auto-sizing
+	// configures; with less memory, the configured storage is used
+	autoSizeMinStorage = 64 * 1024 * 1024
+
+	autoSizeMaxThreadPools = 4
+
+	// the bounds for thread_pool_max; the lower bound is the default of
+	// thread_pool_min, the upper bound the default of thread_pool_max
+	autoSizeMinThreads = 100
+	autoSizeMaxThreads = 5000
+
+	// varnishDefaultThreadPools is the default of the thread_pools parameter
+	varnishDefaultThreadPools = 2
+)
+
+// CheckAutoSizeStorages checks that the sizes of all additional malloc
+// storages are known, since auto-sizing subtracts them from the memory limit
+func CheckAutoSizeStorages(storages []Storage) error {
+	for _, s := range storages {
+		if s.Type == "malloc" && len(s.Args) == 0 {
+			return fmt.Errorf("malloc storage '%s' needs a size when auto-sizing Varnish", s.Name)
+		}
+	}
+
+	return nil
+}
+
+// sizing returns the default storage and the auto-sized parameters; the
+// cgroup limits are only evaluated once
+func (v *VarnishController) sizing() (string, map[string]string) {
+	if !v.AutoSize {
+		return v.Storage, nil
+	}
+
+	v.autoSizeOnce.Do(func() {
+		v.autoSizedStorage, v.autoSizedParams = v.autoSize()
+	})
+
+	return v.autoSizedStorage, v.autoSizedParams
+}
+
+// autoSize derives the storage and thread pool parameters from the cgroup
+// limits of the container. The default storage is sized to the memory limit
+// minus the overhead ratio and the sizes of additional malloc storages; half
+// of the overhead is reserved for worker threads. If a limit is not set, the
+// respective values are not changed.
+func (v *VarnishController) autoSize() (string, map[string]string) {
+	storage := v.Storage
+	params := make(map[string]string)
+
+	limits, err := cgroup.Read(v.cgroupRoot)
+	if err != nil {
+		logger.Warning("could not read cgroup limits; not auto-sizing Varnish", "error", err)
+		return storage, params
+	}
+
+	pools := varnishDefaultThreadPools
+
+	if limits.CPUs > 0 {
+		pools = int(math.Ceil(limits.CPUs))
+		if pools > autoSizeMaxThreadPools {
+			pools = autoSizeMaxThreadPools
+		}
+
+		params["thread_pools"] = strconv.Itoa(pools)
+	}
+
+	if limits.Memory > 0 {
+		overhead := int64(float64(limits.Memory) * v.AutoSizeOverhead)
+
+		size := limits.Memory - overhead
+		for _, s := range v.Storages {
+			size -= s.Size()
+		}
+
+		if size >= autoSizeMinStorage {
+			storage = fmt.Sprintf("malloc,%dM", size/(1024*1024))
+
+			if s, err := ParseStorage(v.Storage, defaultStorageName); err == nil && s.Name != defaultStorageName {
+				storage = s.Name + "=" + storage
+			}
+		} else {
+			logger.Warning("memory limit is too low for auto-sizing the storage", "memory_limit", limits.Memory, "storage", storage)
+		}
+
+		threads := overhead / 2 / autoSizeThreadMemory / int64(pools)
+		if threads < autoSizeMinThreads {
+			threads = autoSizeMinThreads
+		} else if threads > autoSizeMaxThreads {
+			threads = autoSizeMaxThreads
+		}
+
+		params["thread_pool_max"] = strconv.FormatInt(threads, 10)
+	}
+
+	logger.Info("auto-sized Varnish from cgroup limits",
+		"cgroup_version", limits.Version,
+		"memory_limit", limits.Memory,
+		"cpu_limit", limits.CPUs,
+		"storage", storage,
+		"thread_pools", params["thread_pools"],
+		"thread_pool_max", params["thread_pool_max"],
+	)
+
+	return storage, params
+}
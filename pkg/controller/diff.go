@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// maxVCLDiffBytes limits the size of logged VCL diffs, regardless of their
+// number of lines
+const maxVCLDiffBytes = 16 * 1024
+
+// diffVCL returns a unified diff between two rendered VCLs; the diff is
+// empty if they are equal
+func diffVCL(fromName string, from []byte, toName string, to []byte) (string, error) {
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(string(from)),
+		FromFile: fromName,
+		B:        splitLines(string(to)),
+		ToFile:   toName,
+		Context:  3,
+	})
+}
+
+// splitLines splits a VCL into lines, each ending with a line break. Unlike
+// difflib.SplitLines, it does not add an empty line after the final line
+// break, which would show up in every diff that touches the end of the file.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+
+	last := len(lines) - 1
+	if lines[last] == "" {
+		return lines[:last]
+	}
+
+	lines[last] += "\n"
+	return lines
+}
+
+// truncateDiff shortens a diff to at most maxLines lines (and
+// maxVCLDiffBytes bytes), noting how many lines have been omitted
+func truncateDiff(diff string, maxLines int) string {
+	lines := strings.SplitAfter(strings.TrimSuffix(diff, "\n"), "\n")
+
+	var b strings.Builder
+	kept := 0
+
+	for _, line := range lines {
+		if kept >= maxLines || b.Len()+len(line) > maxVCLDiffBytes {
+			break
+		}
+
+		b.WriteString(line)
+		kept++
+	}
+
+	if omitted := len(lines) - kept; omitted > 0 {
+		if !strings.HasSuffix(b.String(), "\n") {
+			b.WriteByte('\n')
+		}
+
+		fmt.Fprintf(&b, "[%d more lines]", omitted)
+	}
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// logVCLDiff logs the changes between the active and a new VCL
+func (v *VarnishController) logVCLDiff(trigger, name string, vcl []byte) {
+	if v.VCLDiffMaxLines <= 0 {
+		return
+	}
+
+	v.stateMutex.RLock()
+	previousName, previous := v.currentVCLName, v.currentVCL
+	v.stateMutex.RUnlock()
+
+	if previous == nil {
+		logger.Debug("rendered new VCL", "trigger", trigger, "vcl_name", name, "vcl", string(vcl))
+		return
+	}
+
+	diff, err := diffVCL(previousName, previous, name, vcl)
+	if err != nil {
+		logger.Warning("error while comparing VCLs", "vcl_name", name, "error", err)
+		return
+	}
+
+	if diff == "" {
+		logger.Info("rendered VCL is unchanged", "trigger", trigger, "vcl_name", name)
+		return
+	}
+
+	logger.Info("rendered VCL has changed", "trigger", trigger, "vcl_name", name, "previous_vcl_name", previousName, "diff", truncateDiff(diff, v.VCLDiffMaxLines))
+}
@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// numberedLines returns a VCL with the given number of lines, where the
+// lines from changedFrom on are marked as changed
+func numberedLines(n, changedFrom int) string {
+	var b strings.Builder
+
+	for i := 0; i < n; i++ {
+		if i >= changedFrom {
+			fmt.Fprintf(&b, "set req.http.x-line-%d = \"changed\";\n", i)
+		} else {
+			fmt.Fprintf(&b, "set req.http.x-line-%d = \"original\";\n", i)
+		}
+	}
+
+	return b.String()
+}
+
+func TestVCLDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		from     string
+		to       string
+		maxLines int
+		diff     string
+	}{
+		{
+			name:     "no change",
+			from:     "vcl 4.0;\nbackend be1 { .host = \"10.0.0.1\"; }\n",
+			to:       "vcl 4.0;\nbackend be1 { .host = \"10.0.0.1\"; }\n",
+			maxLines: 100,
+			diff:     "",
+		},
+		{
+			name:     "small diff",
+			from:     "vcl 4.0;\nbackend be1 { .host = \"10.0.0.1\"; }\n",
+			to:       "vcl 4.0;\nbackend be1 { .host = \"10.0.0.2\"; }\n",
+			maxLines: 100,
+			diff: "--- k8s-upstreamcfg-1\n" +
+				"+++ k8s-upstreamcfg-2\n" +
+				"@@ -1,2 +1,2 @@\n" +
+				" vcl 4.0;\n" +
+				"-backend be1 { .host = \"10.0.0.1\"; }\n" +
+				"+backend be1 { .host = \"10.0.0.2\"; }",
+		},
+		{
+			name:     "no final line break",
+			from:     "vcl 4.0;\nbackend be1 { .host = \"10.0.0.1\"; }",
+			to:       "vcl 4.0;\nbackend be1 { .host = \"10.0.0.2\"; }",
+			maxLines: 100,
+			diff: "--- k8s-upstreamcfg-1\n" +
+				"+++ k8s-upstreamcfg-2\n" +
+				"@@ -1,2 +1,2 @@\n" +
+				" vcl 4.0;\n" +
+				"-backend be1 { .host = \"10.0.0.1\"; }\n" +
+				"+backend be1 { .host = \"10.0.0.2\"; }",
+		},
+		{
+			name:     "diff longer than the limit",
+			from:     numberedLines(10, 10),
+			to:       numberedLines(10, 5),
+			maxLines: 5,
+			diff: "--- k8s-upstreamcfg-1\n" +
+				"+++ k8s-upstreamcfg-2\n" +
+				"@@ -3,8 +3,8 @@\n" +
+				" set req.http.x-line-2 = \"original\";\n" +
+				" set req.http.x-line-3 = \"original\";\n" +
+				"[11 more lines]",
+		},
+	}
+
+	for _, test := range tests {
+		diff, err := diffVCL("k8s-upstreamcfg-1", []byte(test.from), "k8s-upstreamcfg-2", []byte(test.to))
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+
+		if truncated := truncateDiff(diff, test.maxLines); truncated != test.diff {
+			t.Errorf("%s: expected diff\n%s\ngot\n%s", test.name, test.diff, truncated)
+		}
+	}
+}
+
+func TestTruncateDiffLimitsBytes(t *testing.T) {
+	line := strings.Repeat("a", 1023) + "\n"
+	diff := strings.Repeat(line, 20)
+
+	truncated := truncateDiff(diff, 100)
+
+	if len(truncated) > maxVCLDiffBytes+len("\n[100 more lines]") {
+		t.Errorf("expected at most %d bytes, got %d", maxVCLDiffBytes, len(truncated))
+	}
+
+	if !strings.HasSuffix(truncated, "\n[4 more lines]") {
+		t.Errorf("expected 4 lines to be omitted, got %q", truncated[len(truncated)-20:])
+	}
+}
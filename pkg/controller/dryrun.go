@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mittwald/kube-httpcache/pkg/watcher"
+)
+
+// dryRunRender is written next to every rendered VCL in dry-run mode
+type dryRunRender struct {
+	Name     string                  `json:"name"`
+	Trigger  string                  `json:"trigger"`
+	Frontend *watcher.EndpointConfig `json:"frontend"`
+	Backend  *watcher.EndpointConfig `json:"backend"`
+}
+
+// runDryRun renders the VCL on every update without starting or contacting
+// varnishd, until ctx is cancelled
+func (v *VarnishController) runDryRun(ctx context.Context) error {
+	logger.Info("running in dry-run mode; Varnish will not be started")
+
+	if v.DryRunDir != "" {
+		if err := os.MkdirAll(v.DryRunDir, 0755); err != nil {
+			return err
+		}
+	}
+
+	if err := v.reload(ctx, 0, ReloadTriggerInitial); err != nil {
+		return err
+	}
+
+	v.watchConfigUpdatesInBackground(ctx, nil)
+
+	<-ctx.Done()
+	return nil
+}
+
+// renderDryRun renders the VCL and writes it (or a diff against the
+// previous render) along with the current endpoints to stdout or the
+// dry-run directory
+func (v *VarnishController) renderDryRun(i int, trigger string) error {
+	v.stateMutex.RLock()
+	frontend, backend := v.frontend, v.backend
+	previousName, previous := v.currentVCLName, v.currentVCL
+	v.stateMutex.RUnlock()
+
+	buf := new(bytes.Buffer)
+	if err := v.renderVCL(buf, frontend.Endpoints, frontend.Primary, backend.Endpoints, backend.Primary); err != nil {
+		return err
+	}
+
+	vcl := buf.Bytes()
+	name := fmt.Sprintf("dryrun-%d", i)
+
+	output, ext := vcl, "vcl"
+	if v.DryRunDiff && previous != nil {
+		diff, err := diffVCL(previousName, previous, name, vcl)
+		if err != nil {
+			return err
+		}
+
+		output, ext = []byte(diff), "diff"
+	}
+
+	render := dryRunRender{
+		Name:     name,
+		Trigger:  trigger,
+		Frontend: frontend,
+		Backend:  backend,
+	}
+
+	if v.DryRunDir == "" {
+		if err := writeDryRun(os.Stdout, &render, output); err != nil {
+			return err
+		}
+	} else {
+		endpoints, err := json.MarshalIndent(&render, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		prefix := filepath.Join(v.DryRunDir, fmt.Sprintf("%04d-%s", i, trigger))
+
+		if err := writeFileAtomic(prefix+".json", endpoints); err != nil {
+			return err
+		}
+
+		if err := writeFileAtomic(prefix+"."+ext, output); err != nil {
+			return err
+		}
+	}
+
+	logger.Info("rendered VCL in dry-run mode", "vcl_name", name, "trigger", trigger)
+
+	v.stateMutex.Lock()
+	v.currentVCLName = name
+	v.currentVCL = vcl
+	v.stateMutex.Unlock()
+
+	return nil
+}
+
+// writeDryRun writes a render to stdout; the endpoints are prepended as
+// comments, so that the output of a full render is still valid VCL
+func writeDryRun(out *os.File, render *dryRunRender, output []byte) error {
+	frontend, err := json.Marshal(render.Frontend)
+	if err != nil {
+		return err
+	}
+
+	backend, err := json.Marshal(render.Backend)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# ---- %s (trigger: %s)\n", render.Name, render.Trigger)
+	fmt.Fprintf(&b, "# frontend: %s\n", frontend)
+	fmt.Fprintf(&b, "# backend: %s\n", backend)
+
+	if len(output) == 0 {
+		b.WriteString("# (no changes)\n")
+	} else {
+		b.Write(output)
+		if output[len(output)-1] != '\n' {
+			b.WriteByte('\n')
+		}
+	}
+
+	_, err = out.WriteString(b.String())
+	return err
+}
@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mittwald/kube-httpcache/pkg/watcher"
+)
+
+const dryRunTemplate = `{{ range .Backends }}backend {{ .Name }} { .host = "{{ .Host }}"; .port = "{{ .Port }}"; }
+{{ end }}`
+
+func waitForFile(t *testing.T, filename string) []byte {
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		contents, err := ioutil.ReadFile(filename)
+		if err == nil {
+			return contents
+		}
+
+		if !os.IsNotExist(err) || time.Now().After(deadline) {
+			t.Fatalf("expected %s to be written: %v", filename, err)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRunDryRun(t *testing.T) {
+	dir := t.TempDir()
+	templateFile := filepath.Join(dir, "default.vcl.tmpl")
+	outputDir := filepath.Join(dir, "renders")
+
+	if err := ioutil.WriteFile(templateFile, []byte(dryRunTemplate), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// varnishd must never be contacted; any connection to the admin port is
+	// counted
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var connections int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			atomic.AddInt32(&connections, 1)
+			conn.Close()
+		}
+	}()
+
+	frontendUpdates := make(chan *watcher.EndpointConfig, 1)
+	backendUpdates := make(chan *watcher.EndpointConfig, 1)
+
+	v, err := NewVarnishController(
+		filepath.Join(dir, "secret"), "", "", dir, "127.0.0.1", 0,
+		"127.0.0.1", l.Addr().(*net.TCPAddr).Port,
+		frontendUpdates, backendUpdates, nil, nil, nil, templateFile,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v.DryRun = true
+	v.DryRunDiff = true
+	v.DryRunDir = outputDir
+	v.VCLFile = filepath.Join(dir, "default.vcl")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+
+	go func() { errs <- v.Run(ctx) }()
+
+	frontendUpdates <- watcher.NewEndpointConfig()
+	backendUpdates <- &watcher.EndpointConfig{Endpoints: watcher.EndpointList{
+		{Name: "be1", Host: "10.0.0.1", Port: "8080"},
+	}}
+
+	vcl := waitForFile(t, filepath.Join(outputDir, "0000-initial.vcl"))
+	if expected := "backend be1 { .host = \"10.0.0.1\"; .port = \"8080\"; }\n"; string(vcl) != expected {
+		t.Errorf("expected the initial render to be %q, got %q", expected, string(vcl))
+	}
+
+	var render dryRunRender
+	if err := json.Unmarshal(waitForFile(t, filepath.Join(outputDir, "0000-initial.json")), &render); err != nil {
+		t.Fatal(err)
+	}
+
+	if render.Trigger != ReloadTriggerInitial || len(render.Backend.Endpoints) != 1 || render.Backend.Endpoints[0].Name != "be1" {
+		t.Errorf("unexpected endpoints of the initial render: %+v", render)
+	}
+
+	backendUpdates <- &watcher.EndpointConfig{Endpoints: watcher.EndpointList{
+		{Name: "be1", Host: "10.0.0.1", Port: "8080"},
+		{Name: "be2", Host: "10.0.0.2", Port: "8080"},
+	}}
+
+	diff := string(waitForFile(t, filepath.Join(outputDir, "0001-backend.diff")))
+	if !strings.Contains(diff, "--- dryrun-0") || !strings.Contains(diff, "+++ dryrun-1") || !strings.Contains(diff, "+backend be2") {
+		t.Errorf("expected a diff adding be2, got %q", diff)
+	}
+
+	waitForFile(t, filepath.Join(outputDir, "0001-backend.json"))
+
+	cancel()
+
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the dry run to stop")
+	}
+
+	if _, err := os.Stat(v.VCLFile); !os.IsNotExist(err) {
+		t.Errorf("expected the VCL file not to be written in dry-run mode, got %v", err)
+	}
+
+	if n := atomic.LoadInt32(&connections); n != 0 {
+		t.Errorf("expected varnishd not to be contacted, got %d connections", n)
+	}
+}
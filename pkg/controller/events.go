@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	ReasonVarnishStarted      = "VarnishStarted"
+	ReasonVarnishExited       = "VarnishExited"
+	ReasonVCLReloaded         = "VCLReloaded"
+	ReasonVCLRenderFailed     = "VCLRenderFailed"
+	ReasonVCLCompileFailed    = "VCLCompileFailed"
+	ReasonVCLActivationFailed = "VCLActivationFailed"
+	ReasonAdminFailed         = "AdminConnectionFailed"
+	ReasonParamFailed         = "ParameterFailed"
+	ReasonParamNotEffective   = "ParameterNotEffective"
+)
+
+// maxEventMessageLength limits the length of event messages (like compiler
+// output included in them)
+const maxEventMessageLength = 1024
+
+// EventRecorder emits Kubernetes events about the controller on its own pod
+// and, optionally, on a service. Events are sent in the background by the
+// event broadcaster of client-go, which also aggregates repeated events. A
+// nil EventRecorder discards all events.
+type EventRecorder struct {
+	recorder record.EventRecorder
+	objects  []runtime.Object
+}
+
+// NewEventRecorder creates an event recorder for the given pod; if
+// serviceName is not empty, events are also emitted on that service
+func NewEventRecorder(client kubernetes.Interface, podNamespace, podName, serviceNamespace, serviceName string) (*EventRecorder, error) {
+	pod, err := client.CoreV1().Pods(podNamespace).Get(podName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error while looking up pod %s/%s: %s", podNamespace, podName, err.Error())
+	}
+
+	objects := []runtime.Object{pod}
+
+	if serviceName != "" {
+		svc, err := client.CoreV1().Services(serviceNamespace).Get(serviceName, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("error while looking up service %s/%s: %s", serviceNamespace, serviceName, err.Error())
+		}
+
+		objects = append(objects, svc)
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+
+	return &EventRecorder{
+		recorder: broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "kube-httpcache", Host: pod.Spec.NodeName}),
+		objects:  objects,
+	}, nil
+}
+
+// truncateEventMessage shortens a message to maxEventMessageLength bytes,
+// without splitting a UTF-8 encoded character
+func truncateEventMessage(message string) string {
+	if len(message) <= maxEventMessageLength {
+		return message
+	}
+
+	end := maxEventMessageLength - len("...")
+	for end > 0 && !utf8.RuneStart(message[end]) {
+		end--
+	}
+
+	return message[:end] + "..."
+}
+
+// Event emits an event with the given type (v1.EventTypeNormal or
+// v1.EventTypeWarning), reason and message
+func (r *EventRecorder) Event(eventType, reason, message string) {
+	if r == nil {
+		return
+	}
+
+	message = truncateEventMessage(message)
+
+	for _, obj := range r.objects {
+		r.recorder.Event(obj, eventType, reason, message)
+	}
+}
+
+// Eventf emits an event with a formatted message
+func (r *EventRecorder) Eventf(eventType, reason, format string, args ...interface{}) {
+	if r == nil {
+		return
+	}
+
+	r.Event(eventType, reason, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestTruncateEventMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		length  int
+	}{
+		{"short", "VCL reloaded", len("VCL reloaded")},
+		{"exact", strings.Repeat("a", maxEventMessageLength), maxEventMessageLength},
+		{"long", strings.Repeat("a", 2*maxEventMessageLength), maxEventMessageLength},
+		{"multi-byte character at the limit", strings.Repeat("a", maxEventMessageLength-4) + strings.Repeat("ä", 10), maxEventMessageLength - 1},
+	}
+
+	for _, test := range tests {
+		message := truncateEventMessage(test.message)
+
+		if len(message) != test.length {
+			t.Errorf("%s: expected %d bytes, got %d", test.name, test.length, len(message))
+		}
+
+		if !utf8.ValidString(message) {
+			t.Errorf("%s: expected valid UTF-8, got %q", test.name, message)
+		}
+
+		if len(test.message) > maxEventMessageLength && !strings.HasSuffix(message, "...") {
+			t.Errorf("%s: expected the message to end with an ellipsis", test.name)
+		}
+	}
+}
+
+func TestEventRecorder(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	r := &EventRecorder{
+		recorder: recorder,
+		objects:  []runtime.Object{&v1.Pod{}, &v1.Service{}},
+	}
+
+	r.Eventf(v1.EventTypeWarning, ReasonVCLCompileFailed, "compilation of %s failed", "default.vcl")
+
+	for i := 0; i < 2; i++ {
+		if event := <-recorder.Events; event != "Warning VCLCompileFailed compilation of default.vcl failed" {
+			t.Errorf("unexpected event %q", event)
+		}
+	}
+
+	var nilRecorder *EventRecorder
+	nilRecorder.Event(v1.EventTypeNormal, ReasonVCLReloaded, "discarded")
+}
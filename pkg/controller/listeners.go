@@ -0,0 +1,154 @@
+package controller
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	ProtocolHTTP  = "HTTP"
+	ProtocolPROXY = "PROXY"
+)
+
+// defaultListenerName is the name that varnishd assigns to the first
+// unnamed listener, which is the one from FrontendAddr and FrontendPort
+const defaultListenerName = "a0"
+
+// Listener describes an address that varnishd accepts connections on. VCL
+// can tell the listeners apart by their name (in "local.socket").
+type Listener struct {
+	Name     string
+	Address  string
+	Port     int
+	Path     string
+	Protocol string
+	User     string
+	Group    string
+	Mode     string
+}
+
+// IsUnix tells if the listener is a Unix domain socket
+func (l Listener) IsUnix() bool {
+	return l.Path != ""
+}
+
+// Arg returns the listener as argument for the "-a" flag of varnishd
+func (l Listener) Arg() string {
+	arg := l.Name + "="
+
+	if l.IsUnix() {
+		arg += l.Path
+	} else {
+		arg += net.JoinHostPort(l.Address, strconv.Itoa(l.Port))
+	}
+
+	if l.Protocol == ProtocolPROXY {
+		arg += "," + ProtocolPROXY
+	}
+
+	for _, opt := range [][2]string{{"user", l.User}, {"group", l.Group}, {"mode", l.Mode}} {
+		if opt[1] != "" {
+			arg += "," + opt[0] + "=" + opt[1]
+		}
+	}
+
+	return arg
+}
+
+// ParseListener parses a listener spec like "proxy=0.0.0.0:8443,PROXY" or
+// "tls=/var/run/varnish/tls.sock,PROXY,mode=0660", which follows the syntax
+// of the "-a" flag of varnishd (except that a name is required)
+func ParseListener(spec string) (Listener, error) {
+	parts := strings.Split(spec, ",")
+
+	nameAndAddr := strings.SplitN(parts[0], "=", 2)
+	if len(nameAndAddr) != 2 || nameAndAddr[0] == "" || nameAndAddr[1] == "" {
+		return Listener{}, fmt.Errorf("invalid listener '%s'; expected 'name=address:port' or 'name=/path'", spec)
+	}
+
+	l := Listener{Name: nameAndAddr[0], Protocol: ProtocolHTTP}
+
+	if l.Name == defaultListenerName {
+		return l, fmt.Errorf("listener name '%s' is reserved for the frontend listener", l.Name)
+	}
+
+	if strings.HasPrefix(nameAndAddr[1], "/") {
+		l.Path = nameAndAddr[1]
+	} else {
+		host, port, err := net.SplitHostPort(nameAndAddr[1])
+		if err != nil {
+			return l, fmt.Errorf("invalid address of listener '%s': %s", l.Name, err.Error())
+		}
+
+		l.Address = host
+		l.Port, err = strconv.Atoi(port)
+		if err != nil || l.Port < 1 || l.Port > 65535 {
+			return l, fmt.Errorf("invalid port of listener '%s': %s", l.Name, port)
+		}
+	}
+
+	for _, opt := range parts[1:] {
+		kv := strings.SplitN(opt, "=", 2)
+
+		switch {
+		case len(kv) == 1 && (strings.EqualFold(opt, ProtocolHTTP) || strings.EqualFold(opt, ProtocolPROXY)):
+			l.Protocol = strings.ToUpper(opt)
+		case len(kv) == 2 && kv[0] == "user":
+			l.User = kv[1]
+		case len(kv) == 2 && kv[0] == "group":
+			l.Group = kv[1]
+		case len(kv) == 2 && kv[0] == "mode":
+			if _, err := strconv.ParseUint(kv[1], 8, 32); err != nil {
+				return l, fmt.Errorf("invalid socket mode of listener '%s': %s", l.Name, kv[1])
+			}
+
+			l.Mode = kv[1]
+		default:
+			return l, fmt.Errorf("invalid option '%s' of listener '%s'; expected 'HTTP', 'PROXY', 'user=', 'group=' or 'mode='", opt, l.Name)
+		}
+	}
+
+	if !l.IsUnix() && (l.User != "" || l.Group != "" || l.Mode != "") {
+		return l, fmt.Errorf("listener '%s': user, group and mode are only supported for Unix domain sockets", l.Name)
+	}
+
+	return l, nil
+}
+
+// ParseListeners parses a list of listener specs, ensuring that their names
+// are unique
+func ParseListeners(specs []string) ([]Listener, error) {
+	listeners := make([]Listener, 0, len(specs))
+	names := make(map[string]bool, len(specs))
+
+	for _, spec := range specs {
+		l, err := ParseListener(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		if names[l.Name] {
+			return nil, fmt.Errorf("duplicate listener name '%s'", l.Name)
+		}
+
+		names[l.Name] = true
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
+
+// listeners returns all listeners, starting with the frontend listener
+func (v *VarnishController) listeners() []Listener {
+	all := make([]Listener, 0, len(v.Listeners)+1)
+	all = append(all, Listener{
+		Name:     defaultListenerName,
+		Address:  v.FrontendAddr,
+		Port:     v.FrontendPort,
+		Protocol: ProtocolHTTP,
+	})
+
+	return append(all, v.Listeners...)
+}
@@ -0,0 +1,73 @@
+package controller
+
+import "testing"
+
+func TestParseListener(t *testing.T) {
+	tests := []struct {
+		spec     string
+		listener Listener
+		arg      string
+		err      bool
+	}{
+		{
+			spec:     "proxy=0.0.0.0:8443,PROXY",
+			listener: Listener{Name: "proxy", Address: "0.0.0.0", Port: 8443, Protocol: ProtocolPROXY},
+			arg:      "proxy=0.0.0.0:8443,PROXY",
+		},
+		{
+			spec:     "plain=:8081",
+			listener: Listener{Name: "plain", Port: 8081, Protocol: ProtocolHTTP},
+			arg:      "plain=:8081",
+		},
+		{
+			spec:     "v6=[::1]:8081,http",
+			listener: Listener{Name: "v6", Address: "::1", Port: 8081, Protocol: ProtocolHTTP},
+			arg:      "v6=[::1]:8081",
+		},
+		{
+			spec:     "tls=/var/run/varnish/tls.sock,PROXY,user=varnish,group=tls,mode=0660",
+			listener: Listener{Name: "tls", Path: "/var/run/varnish/tls.sock", Protocol: ProtocolPROXY, User: "varnish", Group: "tls", Mode: "0660"},
+			arg:      "tls=/var/run/varnish/tls.sock,PROXY,user=varnish,group=tls,mode=0660",
+		},
+		{spec: "0.0.0.0:8443", err: true},
+		{spec: "=0.0.0.0:8443", err: true},
+		{spec: "a0=0.0.0.0:8443", err: true},
+		{spec: "proxy=0.0.0.0", err: true},
+		{spec: "proxy=0.0.0.0:0", err: true},
+		{spec: "proxy=0.0.0.0:65536", err: true},
+		{spec: "proxy=0.0.0.0:8443,HTTPS", err: true},
+		{spec: "proxy=0.0.0.0:8443,mode=0660", err: true},
+		{spec: "tls=/var/run/varnish/tls.sock,mode=rw", err: true},
+	}
+
+	for _, test := range tests {
+		l, err := ParseListener(test.spec)
+		if (err != nil) != test.err {
+			t.Errorf("%s: unexpected error %v", test.spec, err)
+			continue
+		}
+
+		if test.err {
+			continue
+		}
+
+		if l != test.listener {
+			t.Errorf("%s: expected %+v, got %+v", test.spec, test.listener, l)
+		}
+
+		if arg := l.Arg(); arg != test.arg {
+			t.Errorf("%s: expected argument %s, got %s", test.spec, test.arg, arg)
+		}
+	}
+}
+
+func TestParseListenersRejectsDuplicateNames(t *testing.T) {
+	if _, err := ParseListeners([]string{"proxy=:8443,PROXY", "proxy=:8444"}); err == nil {
+		t.Errorf("expected duplicate listener names to be rejected")
+	}
+
+	listeners, err := ParseListeners([]string{"proxy=:8443,PROXY", "tls=/tmp/tls.sock"})
+	if err != nil || len(listeners) != 2 {
+		t.Errorf("expected 2 listeners, got %d (%v)", len(listeners), err)
+	}
+}
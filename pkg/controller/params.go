@@ -0,0 +1,202 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	ParamApplied         = "applied"
+	ParamUnchanged       = "unchanged"
+	ParamRequiresRestart = "requires-restart"
+	ParamRequiresReload  = "requires-reload"
+	ParamFailed          = "failed"
+)
+
+// ParamResult describes the outcome of applying a single varnishd parameter
+type ParamResult struct {
+	Name    string    `json:"name"`
+	Value   string    `json:"value"`
+	Status  string    `json:"status"`
+	Message string    `json:"message,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// parseParams parses a parameters file, which contains a YAML (or JSON)
+// object that maps parameter names to their values
+func parseParams(contents []byte) (map[string]string, error) {
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(contents, &raw); err != nil {
+		return nil, fmt.Errorf("error while parsing parameters file: %s", err.Error())
+	}
+
+	params := make(map[string]string, len(raw))
+	for name, value := range raw {
+		if name == "" || strings.ContainsAny(name, " \t\r\n=") {
+			return nil, fmt.Errorf("invalid parameter name '%s'", name)
+		}
+
+		switch t := value.(type) {
+		case string:
+			params[name] = t
+		case bool:
+			params[name] = strconv.FormatBool(t)
+		case float64:
+			params[name] = strconv.FormatFloat(t, 'f', -1, 64)
+		default:
+			return nil, fmt.Errorf("parameter '%s' must be a string, number or boolean", name)
+		}
+	}
+
+	return params, nil
+}
+
+// readParams reads the parameters file, if configured
+func (v *VarnishController) readParams() (map[string]string, error) {
+	if v.ParamsFile == "" {
+		return nil, nil
+	}
+
+	contents, err := ioutil.ReadFile(v.ParamsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseParams(contents)
+}
+
+// paramArgs converts parameters into varnishd command line arguments
+func paramArgs(params map[string]string) []string {
+	names := sortedParamNames(params)
+
+	args := make([]string, 0, 2*len(names))
+	for _, name := range names {
+		args = append(args, "-p", name+"="+params[name])
+	}
+
+	return args
+}
+
+func sortedParamNames(params map[string]string) []string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// applyParamsFile parses the contents of the parameters file and applies
+// them to the running varnishd
+func (v *VarnishController) applyParamsFile(ctx context.Context, contents []byte) {
+	params, err := parseParams(contents)
+	if err != nil {
+		logger.Warning("not applying invalid parameters file", "file", v.ParamsFile, "error", err)
+		v.Events.Eventf(v1.EventTypeWarning, ReasonParamFailed, "parameters file %s is invalid: %s", v.ParamsFile, err.Error())
+		return
+	}
+
+	v.applyParams(ctx, params)
+}
+
+// applyParams sets the given parameters via the admin port, skipping those
+// that already have the desired value. Parameters that have been removed
+// from the file keep their current value.
+func (v *VarnishController) applyParams(ctx context.Context, params map[string]string) {
+	results := make([]ParamResult, 0, len(params))
+
+	for _, name := range sortedParamNames(params) {
+		r := v.applyParam(ctx, name, params[name])
+		results = append(results, r)
+
+		switch r.Status {
+		case ParamApplied:
+			logger.Info("applied varnishd parameter", "param", name, "value", r.Value)
+		case ParamRequiresRestart, ParamRequiresReload:
+			logger.Warning("varnishd parameter has been set, but is not yet effective", "param", name, "value", r.Value, "status", r.Status)
+			v.Events.Eventf(v1.EventTypeWarning, ReasonParamNotEffective, "parameter %s=%s has been set, but %s", name, r.Value, r.Message)
+		case ParamFailed:
+			logger.Warning("could not apply varnishd parameter", "param", name, "value", r.Value, "error", r.Message)
+			v.Events.Eventf(v1.EventTypeWarning, ReasonParamFailed, "parameter %s=%s could not be applied: %s", name, r.Value, r.Message)
+		}
+	}
+
+	v.stateMutex.Lock()
+	v.params = results
+	v.stateMutex.Unlock()
+}
+
+func (v *VarnishController) applyParam(ctx context.Context, name, value string) ParamResult {
+	ctx, cancel := context.WithTimeout(ctx, adminCommandTimeout)
+	defer cancel()
+
+	r := ParamResult{Name: name, Value: value, Time: time.Now()}
+
+	description, err := v.admin.Execute(ctx, "param.show", name)
+	if err != nil {
+		r.Status, r.Message = ParamFailed, err.Error()
+		return r
+	}
+
+	if current, ok := paramValue(description); ok && current == value {
+		r.Status = ParamUnchanged
+		return r
+	}
+
+	if _, err := v.admin.Execute(ctx, "param.set", name, value); err != nil {
+		r.Status, r.Message = ParamFailed, err.Error()
+		return r
+	}
+
+	r.Status, r.Message = paramEffect(description)
+	return r
+}
+
+// paramEffect tells from the description of a parameter whether a change
+// takes effect immediately; see the "NB:" notes of "varnishadm param.show".
+// The notes are wrapped across lines, so whitespace is normalized first.
+func paramEffect(description []byte) (string, string) {
+	text := strings.Join(strings.Fields(string(description)), " ")
+
+	switch {
+	case strings.Contains(text, "child process has been restarted"):
+		return ParamRequiresRestart, "it requires a restart of varnishd to take effect"
+	case strings.Contains(text, "VCL has been reloaded"):
+		return ParamRequiresReload, "it requires a VCL reload to take effect"
+	}
+
+	return ParamApplied, ""
+}
+
+// paramValue extracts the current value from the output of
+// "param.show <name>", which lists the name on the first line, followed by
+// an indented line like "Value is: 5000 [threads] (default)"
+func paramValue(description []byte) (string, bool) {
+	for _, line := range strings.Split(string(description), "\n") {
+		i := strings.Index(line, "Value is: ")
+		if i < 0 {
+			continue
+		}
+
+		value := line[i+len("Value is: "):]
+
+		if j := strings.Index(value, " ["); j >= 0 {
+			value = value[:j]
+		} else if j := strings.Index(value, " ("); j >= 0 {
+			value = value[:j]
+		}
+
+		return strings.TrimSpace(value), true
+	}
+
+	return "", false
+}
@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+)
+
+const threadPoolMaxDescription = `thread_pool_max
+        Value is: 5000 [threads] (default)
+        Default is: 5000
+        Minimum is: 100
+
+        The maximum number of worker threads in each pool.
+
+        Do not set this higher than you have to, since excess worker
+        threads soak up RAM and CPU and generally just get in the way
+        of getting work done.
+
+        NB: This parameter may take quite some time to take (full)
+        effect.
+`
+
+const threadPoolsDescription = `thread_pools
+        Value is: 2 [pools] (default)
+        Default is: 2
+        Minimum is: 1
+        Maximum is: 32
+
+        Number of worker thread pools.
+
+        NB: This parameter will not take any effect until the child
+        process has been restarted.
+`
+
+const vccErrUnrefDescription = `vcc_err_unref
+        Value is: off [bool]
+        Default is: on
+
+        Unreferenced VCL objects result in error.
+
+        NB: This parameter will not take any effect until the VCL has
+        been reloaded.
+`
+
+func TestParamValue(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		value       string
+		ok          bool
+	}{
+		{"unit and default", threadPoolMaxDescription, "5000", true},
+		{"unit", vccErrUnrefDescription, "off", true},
+		{"default only", "feature\n        Value is: none (default)\n", "none", true},
+		{"plain value", "vcl_path\n        Value is: /etc/varnish:/usr/share/varnish/vcl\n", "/etc/varnish:/usr/share/varnish/vcl", true},
+		{"short format", "thread_pool_max   Value is: 5000 [threads] (default)\n", "5000", true},
+		{"no value", "thread_pool_max\n", "", false},
+	}
+
+	for _, test := range tests {
+		value, ok := paramValue([]byte(test.description))
+		if value != test.value || ok != test.ok {
+			t.Errorf("%s: expected %q (%t), got %q (%t)", test.name, test.value, test.ok, value, ok)
+		}
+	}
+}
+
+func TestParamEffect(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		status      string
+	}{
+		{"immediate", threadPoolMaxDescription, ParamApplied},
+		{"restart", threadPoolsDescription, ParamRequiresRestart},
+		{"reload", vccErrUnrefDescription, ParamRequiresReload},
+	}
+
+	for _, test := range tests {
+		if status, _ := paramEffect([]byte(test.description)); status != test.status {
+			t.Errorf("%s: expected status %s, got %s", test.name, test.status, status)
+		}
+	}
+}
+
+func TestParseParams(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		params   map[string]string
+		err      bool
+	}{
+		{"yaml", "thread_pool_max: 5000\nvcc_err_unref: false\nfeature: +http2\n", map[string]string{"thread_pool_max": "5000", "vcc_err_unref": "false", "feature": "+http2"}, false},
+		{"json", `{"default_ttl": 120.5}`, map[string]string{"default_ttl": "120.5"}, false},
+		{"invalid name", `{"a=b": 1}`, nil, true},
+		{"invalid value", "thread_pools: [1, 2]\n", nil, true},
+		{"invalid file", "- a\n- b\n", nil, true},
+	}
+
+	for _, test := range tests {
+		params, err := parseParams([]byte(test.contents))
+		if (err != nil) != test.err {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+
+		if !test.err && !reflect.DeepEqual(params, test.params) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.params, params)
+		}
+	}
+}
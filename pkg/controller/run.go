@@ -1,71 +1,235 @@
 package controller
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 
-	"github.com/golang/glog"
+	"github.com/mittwald/kube-httpcache/pkg/varnishadmin"
 	"github.com/mittwald/kube-httpcache/pkg/watcher"
+	v1 "k8s.io/api/core/v1"
 )
 
 func (v *VarnishController) Run(ctx context.Context) error {
-	glog.Infof("waiting for initial configuration before starting Varnish")
+	logger.Info("waiting for initial configuration before starting Varnish")
 
-	v.frontend = watcher.NewEndpointConfig() // assign an empty endpoint to frontend of varnish controller
-	if v.frontendUpdates != nil {            // the watcher function keep it's eye on endpoint channel, if there is a change
-		v.frontend = <-v.frontendUpdates // update the frontend with the item from the channel
+	frontend := watcher.NewEndpointConfig() // assign an empty endpoint to frontend of varnish controller
+	if v.frontendUpdates != nil {           // the watcher function keep it's eye on endpoint channel, if there is a change
+		frontend = <-v.frontendUpdates // update the frontend with the item from the channel
 		if v.varnishSignaller != nil {
-			v.varnishSignaller.SetEndpoints(v.frontend) // update signaller's endpoint
+			v.varnishSignaller.SetEndpoints(frontend) // update signaller's endpoint
 		}
 	}
 
-	v.backend = watcher.NewEndpointConfig()
+	backend := watcher.NewEndpointConfig()
 	if v.backendUpdates != nil {
-		v.backend = <-v.backendUpdates // update backend endconfig
+		backend = <-v.backendUpdates // update backend endconfig
 	}
 
-	target, err := os.Create(v.configFile) // bring up configFile
+	v.stateMutex.Lock()
+	v.frontend = frontend
+	v.backend = backend
+	v.stateMutex.Unlock()
+
+	if v.DryRun {
+		return v.runDryRun(ctx)
+	}
+
+	if v.ExternalVarnish {
+		return v.runExternal(ctx)
+	}
+
+	logger.Info("creating initial VCL config")
+	// Write Endpoints, Primary Endpoint, backend_endpoints, and Primary Backend_endpoint to buf
+	buf := new(bytes.Buffer)
+	err := v.renderVCL(buf, v.frontend.Endpoints, v.frontend.Primary, v.backend.Endpoints, v.backend.Primary)
 	if err != nil {
 		return err
 	}
 
-	glog.Infof("creating initial VCL config")
-	// Write Endpoints, Primary Endpoint, backend_endpoints, and Primary Backend_endpoint to target
-	err = v.renderVCL(target, v.frontend.Endpoints, v.frontend.Primary, v.backend.Endpoints, v.backend.Primary)
+	params, err := v.readParams()
 	if err != nil {
 		return err
 	}
 
-	cmd, errChan := v.startVarnish(ctx)
+	v.stateMutex.Lock()
+	for _, name := range sortedParamNames(params) {
+		v.params = append(v.params, ParamResult{Name: name, Value: params[name], Status: ParamApplied, Message: "passed to varnishd on startup", Time: time.Now()})
+	}
+	v.stateMutex.Unlock()
+
+	v.archiveVCL("boot", buf.Bytes())
+
+	if err := v.writeVCLFile(buf.Bytes()); err != nil {
+		return err
+	}
+
+	v.stateMutex.Lock()
+	v.currentVCLName = "boot"
+	v.currentVCL = buf.Bytes()
+	v.stateMutex.Unlock()
+
+	cmd, errChan := v.startVarnish(ctx, params)
 
 	if err := v.waitForAdminPort(ctx); err != nil {
 		return err
 	}
 
+	v.Events.Event(v1.EventTypeNormal, ReasonVarnishStarted, "Varnish has been started")
+
+	go v.admin.Run(ctx, adminHealthCheckInterval)
+
+	v.watchConfigUpdatesInBackground(ctx, cmd)
+
+	err = <-errChan // channel about error from varnishd cmd
+
+	if ctx.Err() == nil {
+		if err != nil {
+			v.Events.Eventf(v1.EventTypeWarning, ReasonVarnishExited, "Varnish has exited: %s", err.Error())
+		} else {
+			v.Events.Event(v1.EventTypeWarning, ReasonVarnishExited, "Varnish has exited")
+		}
+	}
+
+	return err
+}
+
+// runExternal manages a varnishd that has been started by someone else (like
+// a separate container in the same pod): it waits for the admin port, loads
+// the initial VCL and then keeps the VCL up to date until ctx is cancelled.
+func (v *VarnishController) runExternal(ctx context.Context) error {
+	// VCL names need to be unique for the lifetime of varnishd, which may
+	// exceed the lifetime of the controller
+	v.vclNamePrefix = fmt.Sprintf("k8s-upstreamcfg-%d", time.Now().Unix())
+
+	if err := v.waitForAdminPort(ctx); err != nil {
+		return err
+	}
+
+	// the external varnishd may be restarted independently of the
+	// controller, losing the VCL and the parameters; this is checked
+	// whenever the admin connection has been re-established
+	v.adminReconnects = make(chan struct{}, 1)
+
+	go v.admin.Run(ctx, adminHealthCheckInterval)
+
+	logger.Info("loading initial VCL config into external Varnish")
+
+	if err := v.reload(ctx, 0, ReloadTriggerInitial); err != nil {
+		return err
+	}
+
+	params, err := v.readParams()
+	if err != nil {
+		return err
+	}
+
+	v.applyParams(ctx, params)
+
+	v.watchConfigUpdatesInBackground(ctx, nil)
+
+	<-ctx.Done()
+	return nil
+}
+
+// dialSession establishes the connection of the admin session, and reports
+// it on adminReconnects (if set)
+func (v *VarnishController) dialSession(ctx context.Context) (*varnishadmin.Conn, error) {
+	conn, err := v.dialAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.adminReconnects != nil {
+		select {
+		case v.adminReconnects <- struct{}{}:
+		default:
+		}
+	}
+
+	return conn, nil
+}
+
+// checkExternalVarnish checks if the external varnishd is still using the
+// VCL that has been loaded last. If not, varnishd has been restarted, and
+// the VCL and the parameters are loaded again.
+func (v *VarnishController) checkExternalVarnish(ctx context.Context, i int) error {
+	listCtx, cancel := context.WithTimeout(ctx, adminCommandTimeout)
+	defer cancel()
+
+	list, err := v.admin.Execute(listCtx, "vcl.list")
+	if err != nil {
+		return err
+	}
+
+	active := activeVCLName(list)
+
+	v.stateMutex.RLock()
+	current := v.currentVCLName
+	v.stateMutex.RUnlock()
+
+	if active == current {
+		return nil
+	}
+
+	logger.Warning("external Varnish has been restarted; reloading VCL and parameters", "vcl_name", active, "expected_vcl_name", current)
+
+	// the active VCL is set to cold once the new one has been activated
+	v.stateMutex.Lock()
+	v.currentVCLName = active
+	v.stateMutex.Unlock()
+
+	if err := v.reload(ctx, i, ReloadTriggerRestart); err != nil {
+		return err
+	}
+
+	params, err := v.readParams()
+	if err != nil {
+		return err
+	}
+
+	v.applyParams(ctx, params)
+	return nil
+}
+
+// activeVCLName returns the name of the active VCL from the output of the
+// "vcl.list" command, which contains one line per VCL, starting with its
+// status and ending with its name
+func activeVCLName(list []byte) string {
+	for _, line := range strings.Split(string(list), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "active" {
+			return fields[len(fields)-1]
+		}
+	}
+
+	return ""
+}
+
+func (v *VarnishController) watchConfigUpdatesInBackground(ctx context.Context, c *exec.Cmd) {
 	watchErrors := make(chan error)
-	go v.watchConfigUpdates(ctx, cmd, watchErrors) // this go routine watches for the frontend/backend/template update or error our if the ctx got cancelled
+	go v.watchConfigUpdates(ctx, c, watchErrors) // this go routine watches for the frontend/backend/template update or error our if the ctx got cancelled
 
 	// This go routine basically prints out the logs from the go routine above
 	go func() {
 		for err := range watchErrors {
 			if err != nil {
-				glog.Warningf("error while watching for updates: %s", err.Error())
+				logger.Warning("error while watching for updates", "error", err)
 			}
 		}
 	}()
-
-	return <-errChan // channel about error from varnishd cmd
 }
 
-func (v *VarnishController) startVarnish(ctx context.Context) (*exec.Cmd, <-chan error) {
+func (v *VarnishController) startVarnish(ctx context.Context, params map[string]string) (*exec.Cmd, <-chan error) {
 	// inject certain cmd to the context
 	c := exec.CommandContext(
 		ctx,
 		"varnishd",
-		v.generateArgs()..., // This ... prolly refers that there are multiple outputs
+		v.generateArgs(params)..., // This ... prolly refers that there are multiple outputs
 	)
 
 	// default dir
@@ -84,16 +248,30 @@ func (v *VarnishController) startVarnish(ctx context.Context) (*exec.Cmd, <-chan
 	return c, r
 }
 
-func (v *VarnishController) generateArgs() []string {
+func (v *VarnishController) generateArgs(params map[string]string) []string {
+	storage, autoSizeParams := v.sizing()
+
 	args := []string{
 		"-F",
-		"-f", v.configFile,
+		"-f", v.VCLFile,
 		"-S", v.SecretFile,
-		"-s", v.Storage,
+		"-s", storage,
 		"-a", fmt.Sprintf("%s:%d", v.FrontendAddr, v.FrontendPort),
 		"-T", fmt.Sprintf("%s:%d", v.AdminAddr, v.AdminPort),
 	}
 
+	for _, l := range v.Listeners {
+		args = append(args, "-a", l.Arg())
+	}
+
+	for _, s := range v.Storages {
+		args = append(args, "-s", s.Arg())
+	}
+
+	// auto-sized parameters come first, so that explicitly configured
+	// parameters take precedence
+	args = append(args, paramArgs(autoSizeParams)...)
+
 	if v.AdditionalParameters != "" {
 		for _, val := range strings.Split(v.AdditionalParameters, ",") {
 			args = append(args, "-p")
@@ -101,6 +279,10 @@ func (v *VarnishController) generateArgs() []string {
 		}
 	}
 
+	// parameters from the parameters file are passed after the additional
+	// parameters, so that they take precedence
+	args = append(args, paramArgs(params)...)
+
 	if v.WorkingDir != "" {
 		args = append(args, "-n", v.WorkingDir)
 	}
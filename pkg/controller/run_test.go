@@ -0,0 +1,38 @@
+package controller
+
+import "testing"
+
+func TestActiveVCLName(t *testing.T) {
+	tests := []struct {
+		name   string
+		list   string
+		active string
+	}{
+		{
+			name:   "varnish 6",
+			list:   "available   auto/cold          0 boot\nactive      auto/warm          0 k8s-upstreamcfg-1591012800-3\n",
+			active: "k8s-upstreamcfg-1591012800-3",
+		},
+		{
+			name:   "varnish 7",
+			list:   "active   auto    warm         0    boot\navailable   auto    cold         0    k8s-upstreamcfg-1591012800-0\n",
+			active: "boot",
+		},
+		{
+			name:   "labels",
+			list:   "available  label/warm         0 maintenance -> k8s-upstreamcfg-1\nactive      auto/warm          0 k8s-upstreamcfg-1\n",
+			active: "k8s-upstreamcfg-1",
+		},
+		{
+			name:   "no active VCL",
+			list:   "available   auto/cold          0 boot\n",
+			active: "",
+		},
+	}
+
+	for _, test := range tests {
+		if active := activeVCLName([]byte(test.list)); active != test.active {
+			t.Errorf("%s: expected active VCL %q, got %q", test.name, test.active, active)
+		}
+	}
+}
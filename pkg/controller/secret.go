@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mittwald/kube-httpcache/pkg/varnishadmin"
+)
+
+// EnsureSecretFile generates a random admin secret and writes it to
+// filename, unless that file already exists. It reports whether a new
+// secret has been generated.
+func EnsureSecretFile(filename string) (bool, error) {
+	if _, err := os.Stat(filename); err == nil {
+		return false, nil
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return false, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return false, err
+	}
+
+	secret := []byte(hex.EncodeToString(random) + "\n")
+	if err := ioutil.WriteFile(filename, secret, 0600); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// updateSecret replaces the admin secret; the previous secret is kept for
+// authenticating while varnishd may still be using it
+func (v *VarnishController) updateSecret(secret []byte) {
+	v.secretMutex.Lock()
+	defer v.secretMutex.Unlock()
+
+	if len(secret) == 0 || bytes.Equal(secret, v.secret) {
+		return
+	}
+
+	logger.Info("admin secret has been rotated", "file", v.SecretFile)
+
+	v.previousSecret = v.secret
+	v.secret = secret
+
+	if v.varnishSignaller != nil {
+		v.varnishSignaller.SetAdminSecret(secret)
+	}
+}
+
+// dialAdmin connects and authenticates to the Varnish admin port; the admin
+// session uses it for (re-)connecting. Since the secret file may have been
+// rotated without the controller noticing yet (or varnishd may still be
+// using the previous secret), the secret file is re-read and the previous
+// secret is tried if authentication fails.
+func (v *VarnishController) dialAdmin(ctx context.Context) (*varnishadmin.Conn, error) {
+	v.secretMutex.Lock()
+	secret, previousSecret := v.secret, v.previousSecret
+	v.secretMutex.Unlock()
+
+	conn, err := v.dialAdminWithSecret(ctx, secret)
+	if err == nil {
+		return conn, nil
+	}
+
+	if current, readErr := ioutil.ReadFile(v.SecretFile); readErr == nil && !bytes.Equal(current, secret) {
+		if conn, retryErr := v.dialAdminWithSecret(ctx, current); retryErr == nil {
+			v.updateSecret(current)
+			return conn, nil
+		}
+	}
+
+	if len(previousSecret) > 0 {
+		if conn, retryErr := v.dialAdminWithSecret(ctx, previousSecret); retryErr == nil {
+			logger.Info("authenticated at the admin port with the previous secret", "endpoint", v.localAdminAddr)
+			return conn, nil
+		}
+	}
+
+	return nil, err
+}
+
+func (v *VarnishController) dialAdminWithSecret(ctx context.Context, secret []byte) (*varnishadmin.Conn, error) {
+	conn, err := varnishadmin.Dial(ctx, v.localAdminAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Authenticate(ctx, secret); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// defaultStorageName is the name that varnishd assigns to the first unnamed
+// storage, which is the one from Storage
+const defaultStorageName = "s0"
+
+var storageNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// Storage describes a storage backend (stevedore) of varnishd. VCL can
+// select it by its name, like "set beresp.storage = storage.disk;".
+type Storage struct {
+	Name string
+	Type string
+	Args []string
+}
+
+// Arg returns the storage as argument for the "-s" flag of varnishd
+func (s Storage) Arg() string {
+	return s.Name + "=" + strings.Join(append([]string{s.Type}, s.Args...), ",")
+}
+
+// Size returns the size of a malloc storage in bytes; zero means that the
+// storage is not a malloc storage or is unlimited
+func (s Storage) Size() int64 {
+	if s.Type != "malloc" || len(s.Args) == 0 {
+		return 0
+	}
+
+	size, _ := parseStorageSize(s.Args[0])
+	return size
+}
+
+// ParseStorage parses a storage spec like "memory=malloc,4G" or
+// "disk=file,/data/cache,50G", which follows the syntax of the "-s" flag of
+// varnishd. A spec without a name is named defaultName.
+func ParseStorage(spec, defaultName string) (Storage, error) {
+	s := Storage{Name: defaultName}
+
+	parts := strings.Split(spec, ",")
+	if eq := strings.Index(parts[0], "="); eq >= 0 {
+		s.Name = parts[0][:eq]
+		parts[0] = parts[0][eq+1:]
+	}
+
+	if !storageNamePattern.MatchString(s.Name) {
+		return s, fmt.Errorf("invalid storage name '%s'; it must be a valid VCL identifier", s.Name)
+	}
+
+	s.Type = parts[0]
+	s.Args = parts[1:]
+
+	switch s.Type {
+	case "malloc":
+		if len(s.Args) > 0 {
+			if _, err := parseStorageSize(s.Args[0]); err != nil {
+				return s, fmt.Errorf("invalid size of storage '%s': %s", s.Name, err.Error())
+			}
+		}
+	case "file":
+		if len(s.Args) == 0 || s.Args[0] == "" {
+			return s, fmt.Errorf("file storage '%s' requires a path", s.Name)
+		}
+	case "":
+		return s, fmt.Errorf("storage '%s' has no type", s.Name)
+	}
+
+	return s, nil
+}
+
+// ParseStorages parses a list of additional storage specs, which need to
+// have unique names other than the name of the default storage (given by
+// its spec, since it may have been named, like "memory=malloc,4G")
+func ParseStorages(defaultSpec string, specs []string) ([]Storage, error) {
+	defaultStorage, err := ParseStorage(defaultSpec, defaultStorageName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default storage: %s", err.Error())
+	}
+
+	storages := make([]Storage, 0, len(specs))
+	names := map[string]bool{defaultStorage.Name: true}
+
+	for _, spec := range specs {
+		s, err := ParseStorage(spec, "")
+		if err != nil {
+			return nil, err
+		}
+
+		if names[s.Name] {
+			return nil, fmt.Errorf("duplicate storage name '%s'", s.Name)
+		}
+
+		names[s.Name] = true
+		storages = append(storages, s)
+	}
+
+	return storages, nil
+}
+
+// parseStorageSize parses a size like "512m" or "4G" (with the suffixes
+// that varnishd accepts: k, m, g, t, optionally followed by "b")
+func parseStorageSize(size string) (int64, error) {
+	s := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(size)), "b")
+
+	multiplier := int64(1)
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'k':
+			multiplier = 1 << 10
+		case 'm':
+			multiplier = 1 << 20
+		case 'g':
+			multiplier = 1 << 30
+		case 't':
+			multiplier = 1 << 40
+		}
+
+		if multiplier > 1 {
+			s = s[:n-1]
+		}
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", size)
+	}
+
+	return int64(value * float64(multiplier)), nil
+}
+
+// storages returns all storages, starting with the default storage
+func (v *VarnishController) storages() []Storage {
+	all := make([]Storage, 0, len(v.Storages)+1)
+	defaultStorage, _ := v.sizing()
+
+	s, err := ParseStorage(defaultStorage, defaultStorageName)
+	if err != nil {
+		s = Storage{Name: defaultStorageName, Type: defaultStorage}
+	}
+
+	all = append(all, s)
+	return append(all, v.Storages...)
+}
@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseStorage(t *testing.T) {
+	tests := []struct {
+		spec    string
+		storage Storage
+		arg     string
+		err     bool
+	}{
+		{
+			spec:    "memory=malloc,4G",
+			storage: Storage{Name: "memory", Type: "malloc", Args: []string{"4G"}},
+			arg:     "memory=malloc,4G",
+		},
+		{
+			spec:    "disk=file,/data/cache,50G",
+			storage: Storage{Name: "disk", Type: "file", Args: []string{"/data/cache", "50G"}},
+			arg:     "disk=file,/data/cache,50G",
+		},
+		{
+			spec:    "file,/tmp/varnish-data,1G",
+			storage: Storage{Name: "s0", Type: "file", Args: []string{"/tmp/varnish-data", "1G"}},
+			arg:     "s0=file,/tmp/varnish-data,1G",
+		},
+		{
+			spec:    "unlimited=malloc",
+			storage: Storage{Name: "unlimited", Type: "malloc", Args: []string{}},
+			arg:     "unlimited=malloc",
+		},
+		{spec: "1memory=malloc,4G", err: true},
+		{spec: "mem-ory=malloc,4G", err: true},
+		{spec: "memory=malloc,4X", err: true},
+		{spec: "disk=file", err: true},
+		{spec: "disk=", err: true},
+	}
+
+	for _, test := range tests {
+		s, err := ParseStorage(test.spec, defaultStorageName)
+		if (err != nil) != test.err {
+			t.Errorf("%s: unexpected error %v", test.spec, err)
+			continue
+		}
+
+		if test.err {
+			continue
+		}
+
+		if !reflect.DeepEqual(s, test.storage) {
+			t.Errorf("%s: expected %+v, got %+v", test.spec, test.storage, s)
+		}
+
+		if arg := s.Arg(); arg != test.arg {
+			t.Errorf("%s: expected argument %s, got %s", test.spec, test.arg, arg)
+		}
+	}
+}
+
+func TestParseStorageSize(t *testing.T) {
+	tests := []struct {
+		size  string
+		bytes int64
+		err   bool
+	}{
+		{"1024", 1024, false},
+		{"512k", 512 << 10, false},
+		{"512m", 512 << 20, false},
+		{"4G", 4 << 30, false},
+		{"4GB", 4 << 30, false},
+		{"1t", 1 << 40, false},
+		{"1.5g", 3 << 29, false},
+		{" 2M ", 2 << 20, false},
+		{"", 0, true},
+		{"4X", 0, true},
+		{"-1G", 0, true},
+	}
+
+	for _, test := range tests {
+		bytes, err := parseStorageSize(test.size)
+		if (err != nil) != test.err {
+			t.Errorf("%q: unexpected error %v", test.size, err)
+			continue
+		}
+
+		if bytes != test.bytes {
+			t.Errorf("%q: expected %d bytes, got %d", test.size, test.bytes, bytes)
+		}
+	}
+}
+
+func TestParseStorages(t *testing.T) {
+	tests := []struct {
+		name        string
+		defaultSpec string
+		specs       []string
+		err         bool
+	}{
+		{"unnamed default", "file,/tmp/varnish-data,1G", []string{"memory=malloc,1G", "disk=file,/data,1G"}, false},
+		{"named default", "memory=malloc,1G", []string{"s0=malloc,1G"}, false},
+		{"unnamed default conflict", "file,/tmp/varnish-data,1G", []string{"s0=malloc,1G"}, true},
+		{"named default conflict", "memory=malloc,1G", []string{"memory=malloc,2G"}, true},
+		{"duplicate", "malloc,1G", []string{"disk=file,/a", "disk=file,/b"}, true},
+		{"invalid spec", "malloc,1G", []string{"disk=file"}, true},
+		{"invalid default", "memory=", nil, true},
+	}
+
+	for _, test := range tests {
+		storages, err := ParseStorages(test.defaultSpec, test.specs)
+		if (err != nil) != test.err {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+
+		if !test.err && len(storages) != len(test.specs) {
+			t.Errorf("%s: expected %d storages, got %d", test.name, len(test.specs), len(storages))
+		}
+	}
+}
+
+func TestCheckAutoSizeStorages(t *testing.T) {
+	tests := []struct {
+		name     string
+		storages []Storage
+		err      bool
+	}{
+		{"sized malloc", []Storage{{Name: "memory", Type: "malloc", Args: []string{"1G"}}}, false},
+		{"file", []Storage{{Name: "disk", Type: "file", Args: []string{"/data"}}}, false},
+		{"unsized malloc", []Storage{{Name: "memory", Type: "malloc"}}, true},
+	}
+
+	for _, test := range tests {
+		if err := CheckAutoSizeStorages(test.storages); (err != nil) != test.err {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+	}
+}
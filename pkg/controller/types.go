@@ -3,19 +3,29 @@ package controller
 import (
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"text/template"
 
+	"github.com/mittwald/kube-httpcache/pkg/cgroup"
+	"github.com/mittwald/kube-httpcache/pkg/logging"
 	"github.com/mittwald/kube-httpcache/pkg/signaller"
+	"github.com/mittwald/kube-httpcache/pkg/varnishadmin"
 	"github.com/mittwald/kube-httpcache/pkg/watcher"
 )
 
+var logger = logging.New("controller")
+
 type TemplateData struct {
 	Frontends       watcher.EndpointList
 	PrimaryFrontend *watcher.Endpoint
 	Backends        watcher.EndpointList
 	PrimaryBackend  *watcher.Endpoint
+	Listeners       []Listener
+	Storages        []Storage
 	Env             map[string]string
 }
 
@@ -29,6 +39,51 @@ type VarnishController struct {
 	AdminAddr            string
 	AdminPort            int
 
+	// Listeners are additional addresses that varnishd listens on, besides
+	// FrontendAddr and FrontendPort
+	Listeners []Listener
+
+	// Storages are additional named storages of varnishd, besides Storage
+	Storages []Storage
+
+	// Events receives events about VCL reloads and Varnish restarts, if set
+	Events *EventRecorder
+
+	// VCLFile is the file that contains the active VCL; varnishd is started
+	// with this file
+	VCLFile string
+
+	// VCLArchiveDir is a directory in which the last VCLArchiveSize rendered
+	// VCLs are kept for debugging, if set
+	VCLArchiveDir  string
+	VCLArchiveSize int
+
+	// VCLDiffMaxLines limits the length of the diffs that are logged for
+	// every reload; zero disables logging diffs
+	VCLDiffMaxLines int
+
+	// AutoSize derives the storage size and thread pool parameters from the
+	// container's cgroup limits, leaving AutoSizeOverhead (a fraction of the
+	// memory limit) for everything but the storage
+	AutoSize         bool
+	AutoSizeOverhead float64
+
+	// ParamsFile contains varnishd parameters; they are passed to varnishd
+	// on startup, and changes received on ParamsUpdates are applied live
+	ParamsFile    string
+	ParamsUpdates chan []byte
+
+	// DryRun disables starting and contacting varnishd; instead, every
+	// rendered VCL (or, with DryRunDiff, a diff against the previous render)
+	// is written to DryRunDir, or stdout if empty
+	DryRun     bool
+	DryRunDiff bool
+	DryRunDir  string
+
+	// ExternalVarnish disables starting varnishd; instead, the controller
+	// pushes the VCL to an already running varnishd via its admin port
+	ExternalVarnish bool
+
 	vclTemplate        *template.Template
 	vclTemplateUpdates chan []byte
 	frontendUpdates    chan *watcher.EndpointConfig
@@ -36,10 +91,27 @@ type VarnishController struct {
 	backendUpdates     chan *watcher.EndpointConfig
 	backend            *watcher.EndpointConfig
 	varnishSignaller   *signaller.Signaller
-	configFile         string
+	secretUpdates      chan []byte
 	secret             []byte
+	previousSecret     []byte
+	secretMutex        sync.Mutex
+	admin              *varnishadmin.Session
+	adminReconnects    chan struct{}
 	localAdminAddr     string
+	cgroupRoot         string
+	autoSizeOnce       sync.Once
+	autoSizedStorage   string
+	autoSizedParams    map[string]string
+	vclNamePrefix      string
 	currentVCLName     string
+
+	// the following fields are exposed by the introspection API, and may
+	// only be modified while holding stateMutex
+	currentVCL   []byte
+	templateHash string
+	reloads      []ReloadRecord
+	params       []ParamResult
+	stateMutex   sync.RWMutex
 }
 
 func NewVarnishController(
@@ -54,6 +126,7 @@ func NewVarnishController(
 	frontendUpdates chan *watcher.EndpointConfig,
 	backendUpdates chan *watcher.EndpointConfig,
 	templateUpdates chan []byte,
+	secretUpdates chan []byte,
 	varnishSignaller *signaller.Signaller,
 	vclTemplateFile string,
 ) (*VarnishController, error) {
@@ -67,12 +140,14 @@ func NewVarnishController(
 		return nil, err
 	}
 
+	// the secret is only needed for talking to varnishd, which does not
+	// happen in dry-run mode; dialAdmin re-reads the file anyway
 	secret, err := ioutil.ReadFile(secretFile)
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		return nil, err
 	}
 
-	return &VarnishController{
+	v := &VarnishController{
 		SecretFile:           secretFile,
 		Storage:              storage,
 		AdditionalParameters: additionalParameter,
@@ -86,9 +161,29 @@ func NewVarnishController(
 		frontendUpdates:      frontendUpdates,
 		backendUpdates:       backendUpdates,
 		varnishSignaller:     varnishSignaller,
-		configFile:           "/tmp/vcl",
+		VCLFile:              "/tmp/vcl",
+		cgroupRoot:           cgroup.DefaultRoot,
+		secretUpdates:        secretUpdates,
 		secret:               secret,
-	}, nil
+		localAdminAddr:       adminDialAddress(adminAddr, adminPort),
+		vclNamePrefix:        "k8s-upstreamcfg",
+		templateHash:         templateHash(contents),
+	}
+
+	v.admin = varnishadmin.NewSession(v.dialSession)
+
+	return v, nil
+}
+
+// adminDialAddress returns the address for connecting to the admin port;
+// wildcard listen addresses are reached via the loopback interface
+func adminDialAddress(adminAddr string, adminPort int) string {
+	switch adminAddr {
+	case "", "0.0.0.0", "::":
+		adminAddr = "127.0.0.1"
+	}
+
+	return net.JoinHostPort(adminAddr, strconv.Itoa(adminPort))
 }
 
 func getEnvironment() map[string]string {
@@ -107,6 +202,8 @@ func (v *VarnishController) renderVCL(target io.Writer, frontendList watcher.End
 		PrimaryFrontend: primaryFrontend,
 		Backends:        backendList,
 		PrimaryBackend:  primaryBackend,
+		Listeners:       v.listeners(),
+		Storages:        v.storages(),
 		Env:             getEnvironment(),
 	})
 
@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// archiveTimeFormat prefixes the names of archived VCL files, so that they
+// sort chronologically
+const archiveTimeFormat = "20060102T150405.000000000Z"
+
+// writeVCLFile atomically replaces the VCL file with the active VCL
+func (v *VarnishController) writeVCLFile(vcl []byte) error {
+	return writeFileAtomic(v.VCLFile, vcl)
+}
+
+// archiveVCL stores a rendered VCL in the archive directory (if enabled),
+// named after the reload that it was rendered for, and removes the oldest
+// archived VCLs beyond the archive size
+func (v *VarnishController) archiveVCL(name string, vcl []byte) {
+	if v.VCLArchiveDir == "" || v.VCLArchiveSize <= 0 {
+		return
+	}
+
+	if err := os.MkdirAll(v.VCLArchiveDir, 0755); err != nil {
+		logger.Warning("error while creating VCL archive", "file", v.VCLArchiveDir, "error", err)
+		return
+	}
+
+	filename := filepath.Join(v.VCLArchiveDir, time.Now().UTC().Format(archiveTimeFormat)+"-"+name+".vcl")
+
+	if err := writeFileAtomic(filename, vcl); err != nil {
+		logger.Warning("error while archiving VCL", "vcl_name", name, "file", filename, "error", err)
+		return
+	}
+
+	if err := pruneVCLArchive(v.VCLArchiveDir, v.VCLArchiveSize); err != nil {
+		logger.Warning("error while pruning VCL archive", "file", v.VCLArchiveDir, "error", err)
+	}
+}
+
+func pruneVCLArchive(dir string, size int) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var archived []string
+	for _, f := range files {
+		if f.Mode().IsRegular() && strings.HasSuffix(f.Name(), ".vcl") && len(f.Name()) > len(archiveTimeFormat) {
+			if _, err := time.Parse(archiveTimeFormat, f.Name()[:len(archiveTimeFormat)]); err == nil {
+				archived = append(archived, f.Name())
+			}
+		}
+	}
+
+	if len(archived) <= size {
+		return nil
+	}
+
+	sort.Strings(archived)
+
+	for _, name := range archived[:len(archived)-size] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeFileAtomic writes a file by renaming a synced temporary file, so that
+// readers never observe a partially written file
+func writeFileAtomic(filename string, contents []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func archiveName(t time.Time, name string) string {
+	return t.UTC().Format(archiveTimeFormat) + "-" + name + ".vcl"
+}
+
+func dirContents(t *testing.T, dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func TestPruneVCLArchive(t *testing.T) {
+	start := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	archived := func(minutes int, name string) string {
+		return archiveName(start.Add(time.Duration(minutes)*time.Minute), name)
+	}
+
+	tests := []struct {
+		name      string
+		files     []string
+		size      int
+		remaining []string
+	}{
+		{
+			name:      "below size",
+			files:     []string{archived(0, "a"), archived(1, "b")},
+			size:      3,
+			remaining: []string{archived(0, "a"), archived(1, "b")},
+		},
+		{
+			name:      "at size",
+			files:     []string{archived(0, "a"), archived(1, "b")},
+			size:      2,
+			remaining: []string{archived(0, "a"), archived(1, "b")},
+		},
+		{
+			name:      "oldest are removed",
+			files:     []string{archived(0, "a"), archived(1, "b"), archived(2, "c"), archived(3, "d")},
+			size:      2,
+			remaining: []string{archived(2, "c"), archived(3, "d")},
+		},
+		{
+			name:      "ordered by time, not by name",
+			files:     []string{archived(0, "z"), archived(61, "a"), archived(600, "m")},
+			size:      1,
+			remaining: []string{archived(600, "m")},
+		},
+		{
+			name:      "active and other files are kept",
+			files:     []string{"default.vcl", "notes.txt", "backup-" + archived(0, "a"), archived(1, "b"), archived(2, "c")},
+			size:      1,
+			remaining: []string{archived(2, "c"), "backup-" + archived(0, "a"), "default.vcl", "notes.txt"},
+		},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+
+		for _, name := range test.files {
+			if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := pruneVCLArchive(dir, test.size); err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+
+		sort.Strings(test.remaining)
+
+		if remaining := dirContents(t, dir); !reflect.DeepEqual(remaining, test.remaining) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.remaining, remaining)
+		}
+	}
+}
+
+func TestArchiveVCL(t *testing.T) {
+	dir := t.TempDir()
+
+	v := &VarnishController{
+		VCLFile:        filepath.Join(dir, "default.vcl"),
+		VCLArchiveDir:  dir,
+		VCLArchiveSize: 2,
+	}
+
+	if err := v.writeVCLFile([]byte("active")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"reload_1", "reload_2", "reload_3"} {
+		v.archiveVCL(name, []byte(name))
+	}
+
+	files := dirContents(t, dir)
+	if len(files) != 3 {
+		t.Fatalf("expected the active VCL file and 2 archived VCLs, got %v", files)
+	}
+
+	for i, name := range []string{"reload_2", "reload_3"} {
+		contents, err := ioutil.ReadFile(filepath.Join(dir, files[i]))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(contents) != name {
+			t.Errorf("expected archived VCL %d to be %s, got %s (%s)", i, name, string(contents), files[i])
+		}
+	}
+
+	if contents, err := ioutil.ReadFile(v.VCLFile); err != nil || string(contents) != "active" {
+		t.Errorf("expected the active VCL file to be kept, got %q (%v)", string(contents), err)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "default.vcl")
+
+	if err := ioutil.WriteFile(filename, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// a reader that opened the file before it is replaced keeps reading the
+	// old contents, rather than a partially written file
+	reader, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	if err := writeFileAtomic(filename, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	if contents, err := ioutil.ReadAll(reader); err != nil || string(contents) != "old" {
+		t.Errorf("expected the open file to keep its contents, got %q (%v)", string(contents), err)
+	}
+
+	if contents, err := ioutil.ReadFile(filename); err != nil || string(contents) != "new" {
+		t.Errorf("expected the file to be replaced, got %q (%v)", string(contents), err)
+	}
+
+	if stat, err := os.Stat(filename); err != nil || stat.Mode().Perm() != 0644 {
+		t.Errorf("expected mode 0644, got %v (%v)", stat.Mode().Perm(), err)
+	}
+
+	if files := dirContents(t, dir); !reflect.DeepEqual(files, []string{"default.vcl"}) {
+		t.Errorf("expected no temporary files to be left, got %v", files)
+	}
+
+	if err := writeFileAtomic(filepath.Join(dir, "missing", "default.vcl"), []byte("new")); err == nil {
+		t.Errorf("expected an error for a missing directory")
+	}
+}
@@ -6,9 +6,20 @@ import (
 	"fmt"
 	"os/exec"
 	"text/template"
+	"time"
 
-	"github.com/golang/glog"
-	varnishclient "github.com/martin-helmich/go-varnish-client"
+	"github.com/mittwald/kube-httpcache/pkg/varnishadmin"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// adminCommandTimeout limits the duration of reloading the VCL via the
+	// admin port (including compilation)
+	adminCommandTimeout = 2 * time.Minute
+
+	// adminHealthCheckInterval defines how often the idle admin connection
+	// is checked
+	adminHealthCheckInterval = 10 * time.Second
 )
 
 func (v *VarnishController) watchConfigUpdates(ctx context.Context, c *exec.Cmd, errors chan<- error) {
@@ -19,35 +30,56 @@ func (v *VarnishController) watchConfigUpdates(ctx context.Context, c *exec.Cmd,
 
 		select {
 		case tmplContents := <-v.vclTemplateUpdates: // templateupdates channel got a new item
-			glog.Infof("VCL template was updated")
+			logger.Info("VCL template was updated")
 
 			tmpl, err := template.New("vcl").Parse(string(tmplContents)) // make a new VCL out of the item
 			if err != nil {
+				v.recordReload(ReloadTriggerTemplate, "", time.Now(), err)
 				errors <- err
 				continue
 			}
 
 			v.vclTemplate = tmpl // assign that to varnishController struct
 
-			errors <- v.rebuildConfig(ctx, i)
+			// the hash identifies the template in use, so it is only
+			// updated once the new template has been parsed
+			v.stateMutex.Lock()
+			v.templateHash = templateHash(tmplContents)
+			v.stateMutex.Unlock()
+
+			errors <- v.reload(ctx, i, ReloadTriggerTemplate)
 
 		case newConfig := <-v.frontendUpdates: // frontend channel got a new item
-			glog.Infof("received new frontend configuration: %+v", newConfig)
+			logger.Info("received new frontend configuration", "service", "frontend", "endpoints", len(newConfig.Endpoints))
 
+			v.stateMutex.Lock()
 			v.frontend = newConfig // update the frontend of varnishController
+			v.stateMutex.Unlock()
 
 			if v.varnishSignaller != nil {
 				v.varnishSignaller.SetEndpoints(v.frontend) // update the frontend in the signaller object
 			}
 
-			errors <- v.rebuildConfig(ctx, i)
+			errors <- v.reload(ctx, i, ReloadTriggerFrontend)
 
 		case newConfig := <-v.backendUpdates: // backend channel got a new item
-			glog.Infof("received new backend configuration: %+v", newConfig)
+			logger.Info("received new backend configuration", "service", "backend", "endpoints", len(newConfig.Endpoints))
 
+			v.stateMutex.Lock()
 			v.backend = newConfig // update the backend of varnishController
+			v.stateMutex.Unlock()
+
+			errors <- v.reload(ctx, i, ReloadTriggerBackend) // basically rebuild varnishController with an updated backend
 
-			errors <- v.rebuildConfig(ctx, i) // basically rebuild varnishController with an updated backend
+		case <-v.adminReconnects: // the admin connection to an external varnishd has been re-established
+			errors <- v.checkExternalVarnish(ctx, i)
+
+		case secret := <-v.secretUpdates: // the admin secret file has been rotated
+			v.updateSecret(secret)
+
+		case contents := <-v.ParamsUpdates: // the parameters file has been changed
+			logger.Info("varnishd parameters file was updated", "file", v.ParamsFile)
+			v.applyParamsFile(ctx, contents)
 
 		case <-ctx.Done():
 			errors <- ctx.Err()
@@ -56,49 +88,70 @@ func (v *VarnishController) watchConfigUpdates(ctx context.Context, c *exec.Cmd,
 	}
 }
 
-func (v *VarnishController) rebuildConfig(ctx context.Context, i int) error {
+// adminFailureReason returns the event reason for a failed admin command;
+// if the command could not be issued at all, the admin connection failed
+func adminFailureReason(err error, reason string) string {
+	if _, ok := err.(*varnishadmin.Error); ok {
+		return reason
+	}
+
+	return ReasonAdminFailed
+}
+
+func (v *VarnishController) rebuildConfig(ctx context.Context, i int, trigger string) error {
 	buf := new(bytes.Buffer)
 
 	err := v.renderVCL(buf, v.frontend.Endpoints, v.frontend.Primary, v.backend.Endpoints, v.backend.Primary)
 	if err != nil {
+		v.Events.Eventf(v1.EventTypeWarning, ReasonVCLRenderFailed, "error while rendering VCL template: %s", err.Error())
 		return err
 	}
 
 	vcl := buf.Bytes()
-	glog.V(8).Infof("new VCL: %s", string(vcl))
 
-	client, err := varnishclient.DialTCP(ctx, fmt.Sprintf("127.0.0.1:%d", v.AdminPort))
-	if err != nil {
-		return err
-	}
+	ctx, cancel := context.WithTimeout(ctx, adminCommandTimeout)
+	defer cancel()
 
-	err = client.Authenticate(ctx, v.secret)
-	if err != nil {
-		return err
-	}
+	configname := fmt.Sprintf("%s-%d", v.vclNamePrefix, i)
 
-	configname := fmt.Sprintf("k8s-upstreamcfg-%d", i)
+	v.logVCLDiff(trigger, configname, vcl)
+	v.archiveVCL(configname, vcl)
 
-	err = client.DefineInlineVCL(ctx, configname, vcl, varnishclient.VCLStateAuto) // DefineInlineVCL compiles and loads a new VCL file with the file contents
+	_, err = v.admin.Execute(ctx, "vcl.inline", configname, string(vcl), "auto") // vcl.inline compiles and loads a new VCL file with the file contents
 	if err != nil {
+		v.Events.Eventf(v1.EventTypeWarning, adminFailureReason(err, ReasonVCLCompileFailed), "VCL %s could not be loaded: %s", configname, err.Error())
 		return err
 	}
 
-	err = client.UseVCL(ctx, configname) // UseVCL make Varnish switch to the specified configuration file immediately
+	_, err = v.admin.Execute(ctx, "vcl.use", configname) // vcl.use makes Varnish switch to the specified configuration file immediately
 	if err != nil {
+		v.Events.Eventf(v1.EventTypeWarning, adminFailureReason(err, ReasonVCLActivationFailed), "VCL %s could not be activated: %s", configname, err.Error())
 		return err
 	}
 
+	v.stateMutex.Lock()
 	if v.currentVCLName == "" {
 		v.currentVCLName = "boot"
 	}
+	previousName := v.currentVCLName
+	v.stateMutex.Unlock()
 
 	//SetVCLState can be used to force a loaded VCL file to a specific state. not sure what VCL cold state, but looks to change the state
-	if err := client.SetVCLState(ctx, v.currentVCLName, varnishclient.VCLStateCold); err != nil {
-		glog.V(1).Infof("error while changing state of VCL %s: %s", v.currentVCLName, err)
+	if _, err := v.admin.Execute(ctx, "vcl.state", previousName, "cold"); err != nil {
+		logger.Warning("error while changing state of VCL", "vcl_name", previousName, "error", err)
 	}
 
+	if err := v.writeVCLFile(vcl); err != nil {
+		logger.Warning("error while writing VCL file", "file", v.VCLFile, "error", err)
+	}
+
+	logger.Info("activated new VCL", "vcl_name", configname)
+	v.Events.Eventf(v1.EventTypeNormal, ReasonVCLReloaded, "activated VCL %s", configname)
+
+	v.stateMutex.Lock()
 	v.currentVCLName = configname
+	v.currentVCL = vcl
+	v.stateMutex.Unlock()
 
 	return nil
 }
@@ -0,0 +1,221 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level describes the severity of a log entry
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarning
+	LevelError
+)
+
+const (
+	// FormatText writes log entries as "key=value" lines
+	FormatText = "text"
+
+	// FormatJSON writes log entries as JSON objects, one per line
+	FormatJSON = "json"
+)
+
+var levelNames = []string{"debug", "info", "warning", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return "level(" + strconv.Itoa(int(l)) + ")"
+	}
+
+	return levelNames[l]
+}
+
+// ParseLevel parses the name of a log level
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+
+	if strings.EqualFold(s, "warn") {
+		return LevelWarning, nil
+	}
+
+	return LevelInfo, fmt.Errorf("invalid log level '%s'; expected one of %s", s, strings.Join(levelNames, ", "))
+}
+
+type sink struct {
+	out    io.Writer
+	format string
+	level  Level
+	mutex  sync.Mutex
+}
+
+var std = &sink{out: os.Stderr, format: FormatText, level: LevelInfo}
+
+// Configure sets the output format and the minimum level of all loggers
+func Configure(format string, level Level) error {
+	if format != FormatText && format != FormatJSON {
+		return fmt.Errorf("invalid log format '%s'; expected '%s' or '%s'", format, FormatText, FormatJSON)
+	}
+
+	std.mutex.Lock()
+	defer std.mutex.Unlock()
+
+	std.format = format
+	std.level = level
+
+	return nil
+}
+
+// SetOutput sets the writer that all loggers write to
+func SetOutput(w io.Writer) {
+	std.mutex.Lock()
+	defer std.mutex.Unlock()
+
+	std.out = w
+}
+
+// Logger writes structured log entries. Every entry consists of a message
+// and a list of alternating keys and values; loggers created by With add
+// their fields to every entry.
+type Logger struct {
+	fields []interface{}
+}
+
+// New creates a logger for a component of kube-httpcache
+func New(component string) *Logger {
+	return &Logger{fields: []interface{}{"component", component}}
+}
+
+// With returns a logger that adds the given keys and values to every entry
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyvals...)
+
+	return &Logger{fields: fields}
+}
+
+// Enabled checks if entries of the given level are written
+func (l *Logger) Enabled(level Level) bool {
+	std.mutex.Lock()
+	defer std.mutex.Unlock()
+
+	return level >= std.level
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.log(LevelDebug, msg, keyvals)
+}
+
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.log(LevelInfo, msg, keyvals)
+}
+
+func (l *Logger) Warning(msg string, keyvals ...interface{}) {
+	l.log(LevelWarning, msg, keyvals)
+}
+
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.log(LevelError, msg, keyvals)
+}
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	std.mutex.Lock()
+	defer std.mutex.Unlock()
+
+	if level < std.level {
+		return
+	}
+
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyvals...)
+
+	if len(fields)%2 != 0 {
+		fields = append(fields, "(missing)")
+	}
+
+	buf := new(bytes.Buffer)
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+
+	if std.format == FormatJSON {
+		writeJSON(buf, now, level, msg, fields)
+	} else {
+		writeText(buf, now, level, msg, fields)
+	}
+
+	_, _ = std.out.Write(buf.Bytes())
+}
+
+// fieldValue converts a value into a form that can be written to the log
+func fieldValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case nil:
+		return nil
+	case error:
+		return t.Error()
+	case time.Duration:
+		return t.String()
+	case time.Time:
+		return t.UTC().Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return t.String()
+	case string, bool, int, int32, int64, uint, uint32, uint64, float32, float64:
+		return t
+	default:
+		return fmt.Sprintf("%v", t)
+	}
+}
+
+func writeJSON(buf *bytes.Buffer, now string, level Level, msg string, fields []interface{}) {
+	entry := []interface{}{"time", now, "level", level.String(), "msg", msg}
+	entry = append(entry, fields...)
+
+	buf.WriteByte('{')
+
+	for i := 0; i < len(entry); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, _ := json.Marshal(fmt.Sprint(entry[i]))
+		value, err := json.Marshal(fieldValue(entry[i+1]))
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(entry[i+1]))
+		}
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+
+	buf.WriteString("}\n")
+}
+
+func writeText(buf *bytes.Buffer, now string, level Level, msg string, fields []interface{}) {
+	fmt.Fprintf(buf, "%s %-7s %s", now, strings.ToUpper(level.String()), msg)
+
+	for i := 0; i < len(fields); i += 2 {
+		value := fmt.Sprint(fieldValue(fields[i+1]))
+		if value == "" || strings.ContainsAny(value, " \t\r\n\"=") {
+			value = strconv.Quote(value)
+		}
+
+		fmt.Fprintf(buf, " %v=%s", fields[i], value)
+	}
+
+	buf.WriteByte('\n')
+}
@@ -0,0 +1,237 @@
+package signaller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mittwald/kube-httpcache/pkg/varnishadmin"
+	"github.com/mittwald/kube-httpcache/pkg/watcher"
+)
+
+// BanRequest is the JSON body accepted for BAN requests in admin mode
+type BanRequest struct {
+	Expression string `json:"expression"`
+}
+
+// banExpressionFromRequest translates an incoming signal request into a ban
+// expression that can be passed to the Varnish "ban" admin command
+func banExpressionFromRequest(r *http.Request, body []byte) (string, error) {
+	var expression string
+
+	switch r.Method {
+	case "BAN":
+		expression = r.Header.Get("X-Ban-Expression")
+		if expression == "" && len(body) > 0 {
+			var req BanRequest
+			if err := json.Unmarshal(body, &req); err != nil {
+				return "", fmt.Errorf("invalid ban request body: %s", err.Error())
+			}
+
+			expression = req.Expression
+		}
+
+		if expression == "" {
+			return "", fmt.Errorf("X-Ban-Expression header or JSON body with 'expression' required")
+		}
+
+		args, err := parseBanExpression(expression)
+		if err != nil {
+			return "", err
+		}
+
+		expression = formatBanExpression(args)
+	case "PURGE":
+		expression = "req.url == " + cliQuote(r.URL.RequestURI())
+		if host := r.Header.Get("X-Host"); host != "" {
+			expression += " && req.http.host == " + cliQuote(host)
+		}
+	default:
+		return "", fmt.Errorf("method %s is not supported in admin mode", r.Method)
+	}
+
+	if strings.ContainsAny(expression, "\r\n") {
+		return "", fmt.Errorf("ban expression must not contain line breaks")
+	}
+
+	return expression, nil
+}
+
+// banOperators are the operators that Varnish supports in ban conditions
+var banOperators = map[string]bool{
+	"==": true, "!=": true, "~": true, "!~": true,
+	"<": true, "<=": true, ">": true, ">=": true,
+}
+
+var banFieldPattern = regexp.MustCompile(`^(req|obj)\.[A-Za-z0-9_.-]+$`)
+
+// parseBanExpression splits a ban expression like
+// `req.url ~ "^/foo" && req.http.host == example.com` into the arguments of
+// the "ban" command: one field, operator and argument per condition,
+// separated by "&&"
+func parseBanExpression(expression string) ([]string, error) {
+	tokens, ok := cliTokens(expression)
+	if !ok {
+		return nil, fmt.Errorf("ban expression contains an unterminated quote")
+	}
+
+	if len(tokens) < 3 || (len(tokens)+1)%4 != 0 {
+		return nil, fmt.Errorf("ban expression must consist of conditions like 'field operator argument', joined by '&&'")
+	}
+
+	for i := 0; i < len(tokens); i += 4 {
+		if i > 0 && tokens[i-1] != "&&" {
+			return nil, fmt.Errorf("expected '&&' between ban conditions, got '%s'", tokens[i-1])
+		}
+
+		if !banFieldPattern.MatchString(tokens[i]) {
+			return nil, fmt.Errorf("invalid ban field '%s'; expected 'req.*' or 'obj.*'", tokens[i])
+		}
+
+		if !banOperators[tokens[i+1]] {
+			return nil, fmt.Errorf("invalid ban operator '%s'", tokens[i+1])
+		}
+	}
+
+	return tokens, nil
+}
+
+// formatBanExpression joins the arguments of a "ban" command into a ban
+// expression, quoting the argument of every condition
+func formatBanExpression(args []string) string {
+	conditions := make([]string, 0, (len(args)+1)/4)
+	for i := 0; i+2 < len(args); i += 4 {
+		conditions = append(conditions, args[i]+" "+args[i+1]+" "+cliQuote(args[i+2]))
+	}
+
+	return strings.Join(conditions, " && ")
+}
+
+// banViaAdmin issues a "ban" command on the Varnish admin port of the
+// given endpoint
+func (b *Signaller) banViaAdmin(ctx context.Context, endpoint watcher.Endpoint, expression string) error {
+	args, err := parseBanExpression(expression)
+	if err != nil {
+		return permanent(err)
+	}
+
+	addr := net.JoinHostPort(endpoint.Host, strconv.Itoa(b.AdminPort))
+
+	if _, err := b.adminSession(addr).Execute(ctx, "ban", args...); err != nil {
+		banErr := fmt.Errorf("ban at %s failed: %s", addr, err.Error())
+
+		// syntax and parameter errors will not go away by retrying
+		if adminErr, ok := err.(*varnishadmin.Error); ok && adminErr.Code < varnishadmin.ResponseAuthenticationRequired {
+			return permanent(banErr)
+		}
+
+		return banErr
+	}
+
+	logger.Debug("issued ban", "ban", expression, "endpoint", addr)
+
+	return nil
+}
+
+// adminSessionCheckInterval defines how often idle admin connections to
+// the frontends are checked
+const adminSessionCheckInterval = 10 * time.Second
+
+type adminSession struct {
+	session *varnishadmin.Session
+	cancel  context.CancelFunc
+}
+
+// adminSession returns the long-lived admin session for the admin port at
+// addr, creating it if necessary
+func (b *Signaller) adminSession(addr string) *varnishadmin.Session {
+	b.adminSessionsMutex.Lock()
+	defer b.adminSessionsMutex.Unlock()
+
+	if s, ok := b.adminSessions[addr]; ok {
+		return s.session
+	}
+
+	if b.adminSessions == nil {
+		b.adminSessions = make(map[string]*adminSession)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	session := varnishadmin.NewSession(func(ctx context.Context) (*varnishadmin.Conn, error) {
+		return b.dialAdmin(ctx, addr)
+	})
+
+	go session.Run(ctx, adminSessionCheckInterval)
+
+	b.adminSessions[addr] = &adminSession{session: session, cancel: cancel}
+	return session
+}
+
+// closeAdminSessions closes the admin sessions of removed endpoints
+func (b *Signaller) closeAdminSessions(endpoints watcher.EndpointList) {
+	b.adminSessionsMutex.Lock()
+	defer b.adminSessionsMutex.Unlock()
+
+	for i := range endpoints {
+		addr := net.JoinHostPort(endpoints[i].Host, strconv.Itoa(b.AdminPort))
+
+		if s, ok := b.adminSessions[addr]; ok {
+			s.cancel()
+			delete(b.adminSessions, addr)
+		}
+	}
+}
+
+// dialAdmin connects and authenticates to the admin port at addr. While the
+// secret is being rotated, a frontend may still be using the previous
+// secret, which is tried if authentication with the current one fails.
+func (b *Signaller) dialAdmin(ctx context.Context, addr string) (*varnishadmin.Conn, error) {
+	b.mutex.RLock()
+	secret, previousSecret := b.adminSecret, b.previousAdminSecret
+	b.mutex.RUnlock()
+
+	conn, err := dialAdminWithSecret(ctx, addr, secret)
+	if err == nil {
+		return conn, nil
+	}
+
+	if _, ok := err.(*adminAuthError); ok && len(previousSecret) > 0 {
+		if conn, retryErr := dialAdminWithSecret(ctx, addr, previousSecret); retryErr == nil {
+			logger.Debug("authenticated at the admin port with the previous secret", "endpoint", addr)
+			return conn, nil
+		}
+	}
+
+	return nil, err
+}
+
+// adminAuthError is returned by dialAdminWithSecret if the connection has
+// been established, but authentication failed
+type adminAuthError struct {
+	addr string
+	err  error
+}
+
+func (e *adminAuthError) Error() string {
+	return fmt.Sprintf("authentication at %s failed: %s", e.addr, e.err.Error())
+}
+
+func dialAdminWithSecret(ctx context.Context, addr string, secret []byte) (*varnishadmin.Conn, error) {
+	conn, err := varnishadmin.Dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Authenticate(ctx, secret); err != nil {
+		conn.Close()
+		return nil, &adminAuthError{addr: addr, err: err}
+	}
+
+	return conn, nil
+}
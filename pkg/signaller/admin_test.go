@@ -0,0 +1,215 @@
+package signaller
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mittwald/kube-httpcache/pkg/watcher"
+)
+
+func TestParseBanExpression(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		args       []string
+		formatted  string
+		err        bool
+	}{
+		{
+			name:       "single condition",
+			expression: "obj.http.X-Url ~ ^/path",
+			args:       []string{"obj.http.X-Url", "~", "^/path"},
+			formatted:  `obj.http.X-Url ~ "^/path"`,
+		},
+		{
+			name:       "multiple conditions with quoted argument",
+			expression: `req.url == "/a b" && req.http.host != example.com`,
+			args:       []string{"req.url", "==", "/a b", "&&", "req.http.host", "!=", "example.com"},
+			formatted:  `req.url == "/a b" && req.http.host != "example.com"`,
+		},
+		{
+			name:       "escaped quote",
+			expression: `obj.http.X-Tag == "a\"b"`,
+			args:       []string{"obj.http.X-Tag", "==", `a"b`},
+			formatted:  `obj.http.X-Tag == "a\"b"`,
+		},
+		{
+			name:       "numeric operator",
+			expression: "obj.ttl > 1h",
+			args:       []string{"obj.ttl", ">", "1h"},
+			formatted:  `obj.ttl > "1h"`,
+		},
+		{name: "empty", expression: "", err: true},
+		{name: "missing argument", expression: "req.url ==", err: true},
+		{name: "missing conjunction", expression: "req.url == /a req.url == /b", err: true},
+		{name: "wrong conjunction", expression: "req.url == /a || req.url == /b", err: true},
+		{name: "invalid field", expression: "beresp.ttl == 1s", err: true},
+		{name: "invalid operator", expression: "req.url =~ /a", err: true},
+		{name: "unterminated quote", expression: `req.url == "/a`, err: true},
+		{name: "injected command", expression: `req.url == /a; vcl.discard boot`, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := parseBanExpression(tt.expression)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", args)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("expected args %q, got %q", tt.args, args)
+			}
+
+			if formatted := formatBanExpression(args); formatted != tt.formatted {
+				t.Errorf("expected %q, got %q", tt.formatted, formatted)
+			}
+		})
+	}
+}
+
+// serveFakeAdmin accepts connections like the Varnish admin port, which
+// only authenticate with the given secret, and answers every command after
+// authentication with 200. The number of accepted connections is counted.
+func serveFakeAdmin(t *testing.T, secret string, accepted *int32) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	respond := func(w *bufio.Writer, code int, body string) {
+		fmt.Fprintf(w, "%03d %-8d\n%s\n", code, len(body), body)
+		w.Flush()
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			if accepted != nil {
+				atomic.AddInt32(accepted, 1)
+			}
+
+			go func() {
+				defer conn.Close()
+
+				challenge := "abcdefghijklmnopqrstuvwxyzabcdef"
+				r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+				respond(w, 107, challenge+"\n\nAuthentication required.\n")
+
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+
+				expected := sha256.Sum256([]byte(challenge + "\n" + secret + challenge + "\n"))
+				if strings.TrimSpace(line) != `auth "`+hex.EncodeToString(expected[:])+`"` {
+					respond(w, 500, "Authentication failed.")
+					return
+				}
+
+				respond(w, 200, "Authenticated.")
+
+				for {
+					if _, err := r.ReadString('\n'); err != nil {
+						return
+					}
+
+					respond(w, 200, "")
+				}
+			}()
+		}
+	}()
+
+	return l
+}
+
+func TestBanViaAdminSecretRotation(t *testing.T) {
+	tests := []struct {
+		name           string
+		frontendSecret string
+		ok             bool
+	}{
+		{"current secret", "new", true},
+		{"previous secret", "old", true},
+		{"unknown secret", "other", false},
+	}
+
+	for _, test := range tests {
+		l := serveFakeAdmin(t, test.frontendSecret, nil)
+		_, port, _ := net.SplitHostPort(l.Addr().String())
+
+		b := Signaller{}
+		b.AdminPort, _ = strconv.Atoi(port)
+		b.SetAdminSecret([]byte("old"))
+		b.SetAdminSecret([]byte("new"))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := b.banViaAdmin(ctx, watcher.Endpoint{Host: "127.0.0.1"}, "req.url ~ ^/")
+		cancel()
+		l.Close()
+
+		if (err == nil) != test.ok {
+			t.Errorf("%s: unexpected result %v", test.name, err)
+		}
+	}
+}
+
+func TestBanViaAdminReusesSessions(t *testing.T) {
+	var accepted int32
+
+	l := serveFakeAdmin(t, "secret", &accepted)
+	defer l.Close()
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	endpoint := watcher.Endpoint{Host: "127.0.0.1", Port: "80"}
+
+	b := Signaller{}
+	b.AdminPort, _ = strconv.Atoi(port)
+	b.SetAdminSecret([]byte("secret"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for i := 0; i < 3; i++ {
+		if err := b.banViaAdmin(ctx, endpoint, "req.url ~ ^/"+strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := atomic.LoadInt32(&accepted); n != 1 {
+		t.Errorf("expected all bans to use a single connection, got %d connections", n)
+	}
+
+	b.closeAdminSessions(watcher.EndpointList{endpoint})
+
+	if err := b.banViaAdmin(ctx, endpoint, "req.url ~ ^/"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := atomic.LoadInt32(&accepted); n != 2 {
+		t.Errorf("expected a new connection after the endpoint has been removed, got %d connections", n)
+	}
+
+	b.closeAdminSessions(watcher.EndpointList{endpoint})
+}
@@ -0,0 +1,333 @@
+package signaller
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghodss/yaml"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Authenticator identifies the caller of a signal request. Implementations
+// return ok=false if the request does not carry any credentials they
+// understand, so that the next authenticator can be tried.
+type Authenticator interface {
+	Authenticate(r *http.Request) (caller string, ok bool, err error)
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "Bearer ") {
+		return "", false
+	}
+
+	token := strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+	return token, token != ""
+}
+
+// TokenFileAuthenticator authenticates callers by shared bearer tokens. The
+// token file uses the same CSV format as the static token file of the
+// Kubernetes API server: "token,caller" (additional columns are ignored).
+type TokenFileAuthenticator struct {
+	tokens map[string]string
+}
+
+func NewTokenFileAuthenticator(filename string) (*TokenFileAuthenticator, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing token file %s: %s", filename, err.Error())
+	}
+
+	tokens := make(map[string]string)
+	for i, record := range records {
+		if len(record) < 2 || record[0] == "" || record[1] == "" {
+			return nil, fmt.Errorf("invalid entry in line %d of token file %s; expected 'token,caller'", i+1, filename)
+		}
+
+		tokens[record[0]] = record[1]
+	}
+
+	return &TokenFileAuthenticator{tokens: tokens}, nil
+}
+
+func (a *TokenFileAuthenticator) Authenticate(r *http.Request) (string, bool, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return "", false, nil
+	}
+
+	caller, ok := a.tokens[token]
+	if !ok {
+		return "", false, nil
+	}
+
+	return caller, true, nil
+}
+
+// maxTokenReviewCacheSize limits the number of cached token reviews, so that
+// requests with random tokens cannot exhaust the memory
+const maxTokenReviewCacheSize = 10000
+
+type tokenReviewResult struct {
+	caller  string
+	ok      bool
+	expires time.Time
+}
+
+// TokenReviewAuthenticator authenticates callers by their Kubernetes service
+// account token, using the TokenReview API. Results are cached for cacheTTL,
+// keyed by a hash of the token.
+type TokenReviewAuthenticator struct {
+	client     kubernetes.Interface
+	cacheTTL   time.Duration
+	cache      map[string]tokenReviewResult
+	lastPruned time.Time
+	mutex      sync.Mutex
+}
+
+func NewTokenReviewAuthenticator(client kubernetes.Interface, cacheTTL time.Duration) *TokenReviewAuthenticator {
+	return &TokenReviewAuthenticator{
+		client:   client,
+		cacheTTL: cacheTTL,
+		cache:    make(map[string]tokenReviewResult),
+	}
+}
+
+func (a *TokenReviewAuthenticator) Authenticate(r *http.Request) (string, bool, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return "", false, nil
+	}
+
+	key := tokenHash(token)
+
+	a.mutex.Lock()
+	cached, found := a.cache[key]
+	a.mutex.Unlock()
+
+	if found && time.Now().Before(cached.expires) {
+		return cached.caller, cached.ok, nil
+	}
+
+	review, err := a.client.AuthenticationV1().TokenReviews().Create(&authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token: token,
+		},
+	})
+	if err != nil {
+		return "", false, err
+	}
+
+	if review.Status.Error != "" {
+		logger.Debug("token review failed", "error", review.Status.Error)
+	}
+
+	result := tokenReviewResult{
+		caller:  review.Status.User.Username,
+		ok:      review.Status.Authenticated,
+		expires: time.Now().Add(a.cacheTTL),
+	}
+
+	a.remember(key, result)
+
+	return result.caller, result.ok, nil
+}
+
+// remember caches the result of a token review. Expired results are pruned
+// at most once per cache TTL; if the cache is still full afterwards, the
+// result is not cached.
+func (a *TokenReviewAuthenticator) remember(key string, result tokenReviewResult) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	now := time.Now()
+
+	if now.Sub(a.lastPruned) > a.cacheTTL || len(a.cache) >= maxTokenReviewCacheSize {
+		for k, c := range a.cache {
+			if now.After(c.expires) {
+				delete(a.cache, k)
+			}
+		}
+
+		a.lastPruned = now
+	}
+
+	if len(a.cache) < maxTokenReviewCacheSize {
+		a.cache[key] = result
+	}
+}
+
+func tokenHash(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// ClientCertAuthenticator authenticates callers by the common name of their
+// (already verified) TLS client certificate
+type ClientCertAuthenticator struct{}
+
+func (ClientCertAuthenticator) Authenticate(r *http.Request) (string, bool, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false, nil
+	}
+
+	cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	return cn, cn != "", nil
+}
+
+// PolicyRule allows a caller to send requests with one of the given methods
+// to one of the given path prefixes. Empty lists allow everything.
+type PolicyRule struct {
+	Caller       string   `json:"caller"`
+	Methods      []string `json:"methods"`
+	PathPrefixes []string `json:"pathPrefixes"`
+}
+
+// Policy is an allow-list of the requests that authenticated callers may
+// send. A caller without any rule is denied.
+type Policy struct {
+	Rules []PolicyRule `json:"rules"`
+}
+
+func LoadPolicy(filename string) (*Policy, error) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Policy
+	if err := yaml.Unmarshal(contents, &p); err != nil {
+		return nil, fmt.Errorf("error while parsing policy file %s: %s", filename, err.Error())
+	}
+
+	return &p, nil
+}
+
+// Allows checks if the caller may send the given request. Path prefixes are
+// matched against the cleaned path, so that "/allowed/../other" does not
+// pass as "/allowed".
+func (p *Policy) Allows(caller string, r *http.Request) bool {
+	requestPath := "/"
+	if r.URL.Path != "" {
+		requestPath = path.Clean(r.URL.Path)
+	}
+
+	for _, rule := range p.Rules {
+		if rule.Caller != caller && rule.Caller != "*" {
+			continue
+		}
+
+		if len(rule.Methods) > 0 && !containsFold(rule.Methods, r.Method) {
+			continue
+		}
+
+		if len(rule.PathPrefixes) > 0 && !hasAnyPrefix(requestPath, rule.PathPrefixes) {
+			continue
+		}
+
+		return true
+	}
+
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for i := range list {
+		if strings.EqualFold(list[i], s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasAnyPrefix reports whether a path lies below one of the prefixes. A
+// prefix only matches whole path segments, so "/foo" matches "/foo" and
+// "/foo/bar", but not "/foobar".
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if !strings.HasPrefix(s, prefix) {
+			continue
+		}
+
+		if len(s) == len(prefix) || strings.HasSuffix(prefix, "/") || s[len(prefix)] == '/' {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authenticate identifies the caller of a request with the configured
+// authenticators. If no authenticators are configured, every request is
+// accepted anonymously.
+func (b *Signaller) authenticate(r *http.Request) (string, bool, error) {
+	if len(b.Authenticators) == 0 {
+		return "", true, nil
+	}
+
+	for _, a := range b.Authenticators {
+		caller, ok, err := a.Authenticate(r)
+		if err != nil {
+			return "", false, err
+		}
+
+		if ok {
+			return caller, true, nil
+		}
+	}
+
+	return "", false, nil
+}
+
+// authorize authenticates the caller of a request and checks it against the
+// policy. It writes an error response and returns false if the request must
+// not be processed.
+func (b *Signaller) authorize(w http.ResponseWriter, r *http.Request) (string, bool) {
+	caller, ok, err := b.authenticate(r)
+	if err != nil {
+		logger.Warning("error while authenticating signal request", "error", err)
+		http.Error(w, "authentication failed", http.StatusInternalServerError)
+		return "", false
+	}
+
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="kube-httpcache"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+
+	if b.Policy != nil && !b.Policy.Allows(caller, r) {
+		logger.Warning("caller is not allowed to send signal request", "caller", caller, "method", r.Method, "path", r.URL.Path)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return "", false
+	}
+
+	// credentials are meant for the signaller, not for the frontends
+	if len(b.Authenticators) > 0 {
+		r.Header.Del("Authorization")
+	}
+
+	return caller, true
+}
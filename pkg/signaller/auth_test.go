@@ -0,0 +1,70 @@
+package signaller
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPolicyAllows(t *testing.T) {
+	policy := Policy{Rules: []PolicyRule{
+		{Caller: "cms", Methods: []string{"PURGE"}, PathPrefixes: []string{"/products/"}},
+		{Caller: "admin"},
+		{Caller: "*", Methods: []string{"GET"}, PathPrefixes: []string{"/api/v1/"}},
+		{Caller: "shop", PathPrefixes: []string{"/foo"}},
+	}}
+
+	tests := []struct {
+		name    string
+		caller  string
+		method  string
+		target  string
+		allowed bool
+	}{
+		{name: "matching rule", caller: "cms", method: "PURGE", target: "/products/42", allowed: true},
+		{name: "method is case-insensitive", caller: "cms", method: "purge", target: "/products/42", allowed: true},
+		{name: "wrong method", caller: "cms", method: "BAN", target: "/products/42", allowed: false},
+		{name: "wrong path", caller: "cms", method: "PURGE", target: "/users/42", allowed: false},
+		{name: "path traversal", caller: "cms", method: "PURGE", target: "/products/../users/42", allowed: false},
+		{name: "path inside prefix", caller: "cms", method: "PURGE", target: "/products/a/../42", allowed: true},
+		{name: "empty lists allow everything", caller: "admin", method: "BAN", target: "/anything", allowed: true},
+		{name: "wildcard caller", caller: "someone", method: "GET", target: "/api/v1/leader", allowed: true},
+		{name: "wildcard caller with wrong method", caller: "someone", method: "PURGE", target: "/api/v1/leader", allowed: false},
+		{name: "unknown caller", caller: "someone", method: "PURGE", target: "/products/42", allowed: false},
+		{name: "prefix itself", caller: "shop", method: "PURGE", target: "/foo", allowed: true},
+		{name: "path below prefix", caller: "shop", method: "PURGE", target: "/foo/bar", allowed: true},
+		{name: "prefix followed by slash", caller: "shop", method: "PURGE", target: "/foo/", allowed: true},
+		{name: "longer segment", caller: "shop", method: "PURGE", target: "/foobar", allowed: false},
+		{name: "segment with suffix", caller: "shop", method: "PURGE", target: "/foo-private", allowed: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+
+			if allowed := policy.Allows(tt.caller, r); allowed != tt.allowed {
+				t.Errorf("expected %v, got %v", tt.allowed, allowed)
+			}
+		})
+	}
+}
+
+func TestTokenReviewCache(t *testing.T) {
+	a := NewTokenReviewAuthenticator(nil, time.Minute)
+
+	a.remember(tokenHash("expired"), tokenReviewResult{expires: time.Now().Add(-time.Second)})
+	a.lastPruned = time.Now().Add(-2 * time.Minute)
+	a.remember(tokenHash("valid"), tokenReviewResult{caller: "cms", ok: true, expires: time.Now().Add(time.Minute)})
+
+	if _, ok := a.cache["valid"]; ok {
+		t.Errorf("expected the cache to be keyed by a hash of the token")
+	}
+
+	if _, ok := a.cache[tokenHash("expired")]; ok {
+		t.Errorf("expected the expired result to be pruned")
+	}
+
+	if c, ok := a.cache[tokenHash("valid")]; !ok || c.caller != "cms" {
+		t.Errorf("expected the valid result to be cached, got %+v", c)
+	}
+}
@@ -0,0 +1,196 @@
+package signaller
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxBatchSize limits the number of ban expressions merged into one ban
+const maxBatchSize = 100
+
+// banBatcher collects ban expressions, which are merged and broadcast
+// periodically
+type banBatcher struct {
+	interval time.Duration
+	pending  []string
+	mutex    sync.Mutex
+}
+
+func newBanBatcher(interval time.Duration) *banBatcher {
+	return &banBatcher{interval: interval}
+}
+
+func (c *banBatcher) Add(ban string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.pending = append(c.pending, ban)
+}
+
+func (c *banBatcher) take() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	bans := c.pending
+	c.pending = nil
+
+	return bans
+}
+
+// runBatcher periodically broadcasts the merged ban expressions collected by
+// the batcher
+func (b *Signaller) runBatcher() {
+	for range time.Tick(b.batcher.interval) {
+		bans := b.batcher.take()
+		if len(bans) == 0 {
+			continue
+		}
+
+		merged := mergeBans(bans)
+		logger.Debug("merged ban expressions", "bans", len(bans), "merged", len(merged))
+
+		for _, ban := range merged {
+			t := &signalTemplate{Ban: ban, Received: time.Now()}
+
+			if _, err := b.enqueue(t, false); err != nil {
+				logger.Error("could not broadcast merged ban", "ban", ban, "error", err)
+			}
+		}
+	}
+}
+
+type banGroup struct {
+	field    string
+	first    string
+	patterns []string
+}
+
+// mergeBans merges ban expressions with a single condition on the same field
+// into a single ban matching a regular expression. For example, the bans
+// `obj.http.x-url == /a` and `obj.http.x-url ~ ^/b/` are merged into
+// `obj.http.x-url ~ "^/a$|(?:^/b/)"`. Only string fields can be matched
+// against a regular expression; other ban expressions (like
+// `obj.status == 404`) are returned unchanged.
+func mergeBans(bans []string) []string {
+	var merged []string
+	var groups []*banGroup
+
+	seen := make(map[string]bool)
+	groupsByField := make(map[string]*banGroup)
+
+	for _, ban := range bans {
+		if seen[ban] {
+			continue
+		}
+
+		seen[ban] = true
+
+		tokens, ok := cliTokens(ban)
+		if !ok || len(tokens) != 3 || (tokens[1] != "==" && tokens[1] != "~") || !isStringField(tokens[0]) {
+			merged = append(merged, ban)
+			continue
+		}
+
+		pattern := "^" + regexp.QuoteMeta(tokens[2]) + "$"
+		if tokens[1] == "~" {
+			pattern = "(?:" + tokens[2] + ")"
+		}
+
+		g, ok := groupsByField[tokens[0]]
+		if !ok || len(g.patterns) >= maxBatchSize {
+			g = &banGroup{field: tokens[0], first: ban}
+			groupsByField[tokens[0]] = g
+			groups = append(groups, g)
+		}
+
+		g.patterns = append(g.patterns, pattern)
+	}
+
+	for _, g := range groups {
+		if len(g.patterns) == 1 {
+			merged = append(merged, g.first)
+			continue
+		}
+
+		merged = append(merged, g.field+" ~ "+cliQuote(strings.Join(g.patterns, "|")))
+	}
+
+	return merged
+}
+
+// isStringField reports whether a ban field is a string that can be matched
+// against a regular expression
+func isStringField(field string) bool {
+	field = strings.ToLower(field)
+
+	return field == "req.url" || strings.HasPrefix(field, "req.http.") || strings.HasPrefix(field, "obj.http.")
+}
+
+// cliTokens splits a command line of the Varnish CLI into its arguments.
+// Arguments are separated by whitespace and may be enclosed in double quotes,
+// in which case backslash escapes are resolved.
+func cliTokens(s string) ([]string, bool) {
+	var tokens []string
+
+	for i := 0; i < len(s); {
+		if s[i] == ' ' || s[i] == '\t' {
+			i++
+			continue
+		}
+
+		if s[i] != '"' {
+			j := i
+			for j < len(s) && s[j] != ' ' && s[j] != '\t' {
+				j++
+			}
+
+			tokens = append(tokens, s[i:j])
+			i = j
+			continue
+		}
+
+		var token strings.Builder
+		i++
+
+		for {
+			if i >= len(s) {
+				return nil, false
+			}
+
+			if s[i] == '"' {
+				i++
+				break
+			}
+
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+
+				switch s[i] {
+				case 'n':
+					token.WriteByte('\n')
+				case 't':
+					token.WriteByte('\t')
+				default:
+					token.WriteByte(s[i])
+				}
+
+				i++
+				continue
+			}
+
+			token.WriteByte(s[i])
+			i++
+		}
+
+		tokens = append(tokens, token.String())
+	}
+
+	return tokens, true
+}
+
+// cliQuote encloses an argument of a Varnish CLI command in double quotes
+func cliQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,115 @@
+package signaller
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestMergeBans(t *testing.T) {
+	tests := []struct {
+		name   string
+		bans   []string
+		merged []string
+	}{
+		{
+			name:   "single ban",
+			bans:   []string{`obj.http.X-Url == /a`},
+			merged: []string{`obj.http.X-Url == /a`},
+		},
+		{
+			name:   "equality and regex on the same field",
+			bans:   []string{`obj.http.X-Url == /a`, `obj.http.X-Url ~ ^/b/`},
+			merged: []string{`obj.http.X-Url ~ "^/a$|(?:^/b/)"`},
+		},
+		{
+			name:   "special characters are escaped",
+			bans:   []string{`req.url == "/a?b=1"`, `req.url == "/c.d"`},
+			merged: []string{`req.url ~ "^/a\\?b=1$|^/c\\.d$"`},
+		},
+		{
+			name:   "duplicates are removed",
+			bans:   []string{`req.url == /a`, `req.url == /a`},
+			merged: []string{`req.url == /a`},
+		},
+		{
+			name:   "different fields are not merged",
+			bans:   []string{`req.url == /a`, `obj.http.X-Url == /b`},
+			merged: []string{`req.url == /a`, `obj.http.X-Url == /b`},
+		},
+		{
+			name:   "non-string fields are not merged",
+			bans:   []string{`obj.status == 404`, `obj.status == 410`, `obj.ttl ~ 1`, `obj.ttl ~ 2`},
+			merged: []string{`obj.status == 404`, `obj.status == 410`, `obj.ttl ~ 1`, `obj.ttl ~ 2`},
+		},
+		{
+			name:   "request headers are merged",
+			bans:   []string{`req.http.Host == a.example.com`, `req.http.Host == b.example.com`},
+			merged: []string{`req.http.Host ~ "^a\\.example\\.com$|^b\\.example\\.com$"`},
+		},
+		{
+			name:   "complex bans are kept",
+			bans:   []string{`req.url == /a && req.http.host == example.com`, `obj.status != 200`, `req.url == /b`, `req.url == /c`},
+			merged: []string{`req.url == /a && req.http.host == example.com`, `obj.status != 200`, `req.url ~ "^/b$|^/c$"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if merged := mergeBans(tt.bans); !reflect.DeepEqual(merged, tt.merged) {
+				t.Errorf("expected %q, got %q", tt.merged, merged)
+			}
+		})
+	}
+}
+
+func TestMergeBansLimitsBatchSize(t *testing.T) {
+	bans := make([]string, maxBatchSize+1)
+	for i := range bans {
+		bans[i] = "req.url == /" + strconv.Itoa(i)
+	}
+
+	if merged := mergeBans(bans); len(merged) != 2 {
+		t.Errorf("expected %d bans to be merged into 2, got %d", len(bans), len(merged))
+	}
+}
+
+func TestCLIQuote(t *testing.T) {
+	tests := []string{
+		`/plain`,
+		`with space`,
+		`"quoted"`,
+		`back\slash`,
+		`^/a$|(?:^/b/)`,
+	}
+
+	for _, s := range tests {
+		quoted := cliQuote(s)
+
+		tokens, ok := cliTokens("req.url == " + quoted)
+		if !ok || len(tokens) != 3 || tokens[2] != s {
+			t.Errorf("expected %q to round-trip through %s, got %q", s, quoted, tokens)
+		}
+	}
+}
+
+func TestCLITokens(t *testing.T) {
+	tests := []struct {
+		line   string
+		tokens []string
+		ok     bool
+	}{
+		{line: `req.url ~ ^/a`, tokens: []string{"req.url", "~", "^/a"}, ok: true},
+		{line: "  req.url\t==  /a ", tokens: []string{"req.url", "==", "/a"}, ok: true},
+		{line: `req.url == "a \"b\" \\ c"`, tokens: []string{"req.url", "==", `a "b" \ c`}, ok: true},
+		{line: `obj.http.X == "a\nb"`, tokens: []string{"obj.http.X", "==", "a\nb"}, ok: true},
+		{line: `req.url == "/a`, ok: false},
+	}
+
+	for _, tt := range tests {
+		tokens, ok := cliTokens(tt.line)
+		if ok != tt.ok || (ok && !reflect.DeepEqual(tokens, tt.tokens)) {
+			t.Errorf("cliTokens(%q): expected %q (%v), got %q (%v)", tt.line, tt.tokens, tt.ok, tokens, ok)
+		}
+	}
+}
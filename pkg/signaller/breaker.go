@@ -0,0 +1,97 @@
+package signaller
+
+import (
+	"sync"
+	"time"
+)
+
+type breakerState struct {
+	failures  int
+	openUntil time.Time
+}
+
+// circuitBreaker keeps track of consecutive delivery failures per endpoint.
+// After threshold failures, the circuit opens and no signals are delivered to
+// the endpoint until the cooldown has passed; after that, a single signal is
+// let through to probe the endpoint.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	endpoints map[string]*breakerState
+	mutex     sync.Mutex
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		endpoints: make(map[string]*breakerState),
+	}
+}
+
+// Allow checks if a signal may be delivered to the endpoint. If not, it
+// returns the point in time at which delivery should be attempted again.
+func (c *circuitBreaker) Allow(endpoint string) (bool, time.Time) {
+	if c.threshold <= 0 {
+		return true, time.Time{}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	state, ok := c.endpoints[endpoint]
+	if !ok || state.failures < c.threshold {
+		return true, time.Time{}
+	}
+
+	now := time.Now()
+	if now.Before(state.openUntil) {
+		return false, state.openUntil
+	}
+
+	// half-open; let this signal probe the endpoint and hold back all others
+	// until its result is known (or the cooldown has passed once more)
+	state.openUntil = now.Add(c.cooldown)
+	return true, time.Time{}
+}
+
+// Success closes the circuit of an endpoint
+func (c *circuitBreaker) Success(endpoint string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.endpoints, endpoint)
+}
+
+// Failure records a failed delivery to an endpoint, and reports whether this
+// failure has opened the circuit
+func (c *circuitBreaker) Failure(endpoint string) bool {
+	if c.threshold <= 0 {
+		return false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	state, ok := c.endpoints[endpoint]
+	if !ok {
+		state = &breakerState{}
+		c.endpoints[endpoint] = state
+	}
+
+	state.failures++
+	if state.failures < c.threshold {
+		return false
+	}
+
+	state.openUntil = time.Now().Add(c.cooldown)
+	return state.failures == c.threshold
+}
+
+// Forget removes the state of an endpoint
+func (c *circuitBreaker) Forget(endpoint string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.endpoints, endpoint)
+}
@@ -0,0 +1,195 @@
+package signaller
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mittwald/kube-httpcache/pkg/watcher"
+)
+
+const (
+	// WaitHeader makes the signaller wait (up to the given duration) for the
+	// broadcast to complete and respond with a report of every endpoint
+	WaitHeader = "X-Signaller-Wait"
+
+	// AsyncHeader makes the signaller respond with a broadcast ID that can be
+	// polled at BroadcastsPath
+	AsyncHeader = "X-Signaller-Async"
+
+	// BroadcastsPath is the path prefix under which broadcast reports can be
+	// retrieved by their ID
+	BroadcastsPath = "/api/v1/broadcasts/"
+
+	// broadcastRetention defines how long reports of broadcasts are kept
+	broadcastRetention = 15 * time.Minute
+)
+
+// EndpointResult describes the outcome of a signal sent to a single endpoint
+type EndpointResult struct {
+	Endpoint   string `json:"endpoint"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Attempts   int    `json:"attempts"`
+	Error      string `json:"error,omitempty"`
+	Done       bool   `json:"done"`
+}
+
+// Broadcast tracks the results of a signal request sent to all endpoints
+type Broadcast struct {
+	ID      string           `json:"id"`
+	Created time.Time        `json:"created"`
+	Done    bool             `json:"done"`
+	Results []EndpointResult `json:"results"`
+
+	pending int
+	done    chan struct{}
+	mutex   sync.Mutex
+}
+
+func newBroadcast(endpoints []watcher.Endpoint) (*Broadcast, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	br := &Broadcast{
+		ID:      hex.EncodeToString(id),
+		Created: time.Now(),
+		Results: make([]EndpointResult, len(endpoints)),
+		pending: len(endpoints),
+		done:    make(chan struct{}),
+	}
+
+	for i := range endpoints {
+		br.Results[i].Endpoint = net.JoinHostPort(endpoints[i].Host, endpoints[i].Port)
+	}
+
+	if br.pending == 0 {
+		br.Done = true
+		close(br.done)
+	}
+
+	return br, nil
+}
+
+func (br *Broadcast) record(index, attempts, statusCode int, err error, final bool) {
+	br.mutex.Lock()
+	defer br.mutex.Unlock()
+
+	result := &br.Results[index]
+	if result.Done {
+		return
+	}
+
+	result.Attempts = attempts
+	result.StatusCode = statusCode
+	result.Error = ""
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	if !final {
+		return
+	}
+
+	result.Done = true
+	br.pending--
+
+	if br.pending == 0 {
+		br.Done = true
+		close(br.done)
+	}
+}
+
+// Wait blocks until the broadcast has completed or the timeout has passed,
+// and reports whether the broadcast has completed
+func (br *Broadcast) Wait(timeout time.Duration) bool {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case <-br.done:
+		return true
+	case <-t.C:
+		return false
+	}
+}
+
+// Succeeded reports whether the signal was delivered to every endpoint
+func (br *Broadcast) Succeeded() bool {
+	br.mutex.Lock()
+	defer br.mutex.Unlock()
+
+	for i := range br.Results {
+		if !br.Results[i].Done || br.Results[i].Error != "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// snapshot returns a copy of the broadcast's current results, which can be
+// encoded without holding the lock
+func (br *Broadcast) snapshot() *Broadcast {
+	br.mutex.Lock()
+	defer br.mutex.Unlock()
+
+	return &Broadcast{
+		ID:      br.ID,
+		Created: br.Created,
+		Done:    br.Done,
+		Results: append([]EndpointResult(nil), br.Results...),
+	}
+}
+
+func (br *Broadcast) writeJSON(w http.ResponseWriter, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(br.snapshot()); err != nil {
+		logger.Warning("error while writing broadcast report", "error", err)
+	}
+}
+
+// trackBroadcast stores a broadcast so that it can be polled later. Broadcasts
+// older than broadcastRetention are pruned at most once per retention period.
+func (b *Signaller) trackBroadcast(br *Broadcast) {
+	b.broadcastsMutex.Lock()
+	defer b.broadcastsMutex.Unlock()
+
+	now := time.Now()
+
+	if now.Sub(b.broadcastsPruned) > broadcastRetention {
+		for id, old := range b.broadcasts {
+			if now.Sub(old.Created) > broadcastRetention {
+				delete(b.broadcasts, id)
+			}
+		}
+
+		b.broadcastsPruned = now
+	}
+
+	b.broadcasts[br.ID] = br
+}
+
+func (b *Signaller) serveBroadcast(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, BroadcastsPath)
+
+	b.broadcastsMutex.Lock()
+	br, ok := b.broadcasts[id]
+	b.broadcastsMutex.Unlock()
+
+	// expired broadcasts may not have been pruned yet
+	if !ok || time.Since(br.Created) > broadcastRetention {
+		http.Error(w, "broadcast not found", http.StatusNotFound)
+		return
+	}
+
+	br.writeJSON(w, http.StatusOK)
+}
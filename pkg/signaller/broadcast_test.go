@@ -0,0 +1,174 @@
+package signaller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testBroadcast(t *testing.T, names ...string) *Broadcast {
+	br, err := newBroadcast(testEndpoints(names...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return br
+}
+
+func decodeBroadcast(t *testing.T, rec *httptest.ResponseRecorder) *Broadcast {
+	br := &Broadcast{}
+	if err := json.NewDecoder(rec.Body).Decode(br); err != nil {
+		t.Fatal(err)
+	}
+
+	return br
+}
+
+func TestBroadcastRecord(t *testing.T) {
+	br := testBroadcast(t, "a1", "b2")
+
+	br.record(0, 1, http.StatusServiceUnavailable, errors.New("unavailable"), false)
+	if br.Results[0].Done || br.Results[0].Error != "unavailable" {
+		t.Errorf("expected a pending result with error, got %+v", br.Results[0])
+	}
+
+	br.record(0, 2, http.StatusOK, nil, true)
+	br.record(0, 3, http.StatusInternalServerError, errors.New("late"), true)
+	if r := br.Results[0]; !r.Done || r.Attempts != 2 || r.StatusCode != http.StatusOK || r.Error != "" {
+		t.Errorf("expected the first final result to be kept, got %+v", r)
+	}
+
+	if br.Wait(10 * time.Millisecond) {
+		t.Errorf("expected the broadcast to be pending")
+	}
+
+	br.record(1, 1, http.StatusOK, nil, true)
+	if !br.Wait(time.Second) || !br.Done {
+		t.Errorf("expected the broadcast to be done")
+	}
+
+	if !br.Succeeded() {
+		t.Errorf("expected the broadcast to have succeeded")
+	}
+}
+
+func TestBroadcastWithoutEndpoints(t *testing.T) {
+	br := testBroadcast(t)
+
+	if !br.Wait(time.Second) || !br.Succeeded() {
+		t.Errorf("expected a broadcast without endpoints to be done")
+	}
+}
+
+func TestRespond(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []error
+		status  int
+	}{
+		{"all delivered", []error{nil, nil}, http.StatusOK},
+		{"partial failure", []error{nil, errors.New("connection refused")}, http.StatusBadGateway},
+		{"wait timeout", []error{nil}, http.StatusGatewayTimeout},
+	}
+
+	b := &Signaller{}
+
+	for _, test := range tests {
+		br := testBroadcast(t, "a1", "b2")
+		for i, err := range test.results {
+			br.record(i, 1, 0, err, true)
+		}
+
+		rec := httptest.NewRecorder()
+		b.respond(rec, br, 10*time.Millisecond, false)
+
+		if rec.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.status, rec.Code)
+		}
+
+		if report := decodeBroadcast(t, rec); report.ID != br.ID || len(report.Results) != 2 {
+			t.Errorf("%s: unexpected report %+v", test.name, report)
+		}
+	}
+}
+
+func TestServeBroadcast(t *testing.T) {
+	b := &Signaller{broadcasts: make(map[string]*Broadcast)}
+
+	br := testBroadcast(t, "a1")
+	b.trackBroadcast(br)
+
+	rec := httptest.NewRecorder()
+	b.respond(rec, br, 0, true)
+
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d", http.StatusAccepted, rec.Code)
+	}
+
+	var accepted map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&accepted); err != nil {
+		t.Fatal(err)
+	}
+
+	poll := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		b.serveBroadcast(rec, httptest.NewRequest(http.MethodGet, accepted["location"], nil))
+		return rec
+	}
+
+	rec = poll()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	if report := decodeBroadcast(t, rec); report.ID != accepted["id"] || report.Done {
+		t.Errorf("expected a pending report for %s, got %+v", accepted["id"], report)
+	}
+
+	br.record(0, 1, http.StatusOK, nil, true)
+
+	if report := decodeBroadcast(t, poll()); !report.Done || !report.Results[0].Done {
+		t.Errorf("expected a completed report, got %+v", report)
+	}
+
+	rec = httptest.NewRecorder()
+	b.serveBroadcast(rec, httptest.NewRequest(http.MethodGet, BroadcastsPath+"unknown", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for an unknown ID, got %d", http.StatusNotFound, rec.Code)
+	}
+
+	// expired reports are not served, even before they are pruned
+	br.Created = time.Now().Add(-broadcastRetention - time.Second)
+	if rec := poll(); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d after the retention period, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestTrackBroadcastPrunes(t *testing.T) {
+	b := &Signaller{broadcasts: make(map[string]*Broadcast)}
+
+	expired := testBroadcast(t, "a1")
+	expired.Created = time.Now().Add(-broadcastRetention - time.Second)
+
+	b.trackBroadcast(expired)
+	b.trackBroadcast(testBroadcast(t, "a1"))
+
+	// pruned recently, so the expired broadcast is kept for now
+	if _, ok := b.broadcasts[expired.ID]; !ok {
+		t.Errorf("expected no pruning within the retention period")
+	}
+
+	b.broadcastsPruned = time.Now().Add(-broadcastRetention - time.Second)
+	b.trackBroadcast(testBroadcast(t, "a1"))
+
+	if _, ok := b.broadcasts[expired.ID]; ok {
+		t.Errorf("expected the expired broadcast to be pruned")
+	}
+
+	if len(b.broadcasts) != 2 {
+		t.Errorf("expected 2 broadcasts, got %d", len(b.broadcasts))
+	}
+}
@@ -0,0 +1,153 @@
+package signaller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dedupIgnoredHeaders are not part of the key of a signal request, since
+// they differ between clients (or carry their credentials)
+var dedupIgnoredHeaders = map[string]bool{
+	"Authorization":   true,
+	"X-Forwarded-For": true,
+	ForwardedHeader:   true,
+}
+
+// key identifies identical signal requests, regardless of the client that
+// sent them
+func (t *signalTemplate) key() string {
+	h := sha256.New()
+
+	io.WriteString(h, t.Method+"\n"+t.RequestURI+"\n"+t.Host+"\n"+t.Ban+"\n")
+	io.WriteString(h, strings.Join(t.Targets, ",")+"\n")
+
+	names := make([]string, 0, len(t.Header))
+	for name := range t.Header {
+		if !dedupIgnoredHeaders[name] {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		io.WriteString(h, name+": "+strings.Join(t.Header[name], ", ")+"\n")
+	}
+
+	h.Write(t.Body)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// dedupEntry is a signal request received within the window. Its broadcast
+// is set, and ready is closed, once the request has been submitted.
+type dedupEntry struct {
+	seen      time.Time
+	tracked   bool
+	failed    bool
+	broadcast *Broadcast
+	ready     chan struct{}
+}
+
+// deduplicator remembers the signal requests received within a time window
+type deduplicator struct {
+	window     time.Duration
+	entries    map[string]*dedupEntry
+	lastPruned time.Time
+	mutex      sync.Mutex
+}
+
+func newDeduplicator(window time.Duration) *deduplicator {
+	return &deduplicator{
+		window:  window,
+		entries: make(map[string]*dedupEntry),
+	}
+}
+
+// Reserve atomically checks if an identical signal request has been received
+// within the window. If so, it waits until that request has been submitted
+// and returns its broadcast (if its results are tracked). Otherwise, the key
+// is reserved, and the caller needs to call Complete or Release once it has
+// submitted the request. If track is set, requests whose results are not
+// tracked do not count as duplicates.
+func (d *deduplicator) Reserve(key string, track bool) (*dedupEntry, *Broadcast, bool) {
+	if d.window <= 0 {
+		return nil, nil, false
+	}
+
+	for {
+		d.mutex.Lock()
+		d.prune()
+
+		entry, ok := d.entries[key]
+		if !ok || time.Since(entry.seen) > d.window || (track && !entry.tracked) {
+			reservation := &dedupEntry{seen: time.Now(), tracked: track, ready: make(chan struct{})}
+			d.entries[key] = reservation
+			d.mutex.Unlock()
+
+			return reservation, nil, false
+		}
+
+		d.mutex.Unlock()
+		<-entry.ready
+
+		// the original request could not be submitted; try again
+		if entry.failed {
+			continue
+		}
+
+		return nil, entry.broadcast, true
+	}
+}
+
+// Complete marks a reserved signal request as submitted
+func (d *deduplicator) Complete(reservation *dedupEntry, broadcast *Broadcast) {
+	if reservation == nil {
+		return
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	reservation.broadcast = broadcast
+	close(reservation.ready)
+}
+
+// Release discards the reservation of a signal request that could not be
+// submitted
+func (d *deduplicator) Release(key string, reservation *dedupEntry) {
+	if reservation == nil {
+		return
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.entries[key] == reservation {
+		delete(d.entries, key)
+	}
+
+	reservation.failed = true
+	close(reservation.ready)
+}
+
+// prune removes expired entries at most once per window. The caller needs
+// to hold the mutex.
+func (d *deduplicator) prune() {
+	if time.Since(d.lastPruned) <= d.window {
+		return
+	}
+
+	for k, entry := range d.entries {
+		if time.Since(entry.seen) > d.window {
+			delete(d.entries, k)
+		}
+	}
+
+	d.lastPruned = time.Now()
+}
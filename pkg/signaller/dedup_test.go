@@ -0,0 +1,124 @@
+package signaller
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSignalTemplateKey(t *testing.T) {
+	template := func(header http.Header) *signalTemplate {
+		return &signalTemplate{Method: "PURGE", RequestURI: "/a", Host: "example.com", Header: header}
+	}
+
+	base := template(http.Header{"X-Host": {"example.com"}}).key()
+
+	tests := []struct {
+		name   string
+		header http.Header
+		equal  bool
+	}{
+		{name: "other client", header: http.Header{"X-Host": {"example.com"}, "X-Forwarded-For": {"10.0.0.1"}}, equal: true},
+		{name: "other credentials", header: http.Header{"X-Host": {"example.com"}, "Authorization": {"Bearer other"}}, equal: true},
+		{name: "forwarded by a follower", header: http.Header{"X-Host": {"example.com"}, ForwardedHeader: {"pod-1"}}, equal: true},
+		{name: "other header value", header: http.Header{"X-Host": {"example.org"}}, equal: false},
+		{name: "additional header", header: http.Header{"X-Host": {"example.com"}, "X-Other": {"1"}}, equal: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if equal := template(tt.header).key() == base; equal != tt.equal {
+				t.Errorf("expected equal keys to be %v", tt.equal)
+			}
+		})
+	}
+}
+
+func TestDeduplicator(t *testing.T) {
+	d := newDeduplicator(time.Minute)
+
+	reservation, _, duplicate := d.Reserve("a", false)
+	if duplicate || reservation == nil {
+		t.Fatalf("expected the first request to be reserved")
+	}
+
+	d.Complete(reservation, nil)
+
+	if _, _, duplicate := d.Reserve("a", false); !duplicate {
+		t.Errorf("expected an identical request to be a duplicate")
+	}
+
+	// a request that waits for its results is not a duplicate of an
+	// untracked one, but becomes the original for later tracked requests
+	tracked, _, duplicate := d.Reserve("a", true)
+	if duplicate {
+		t.Fatalf("expected a tracked request not to be a duplicate of an untracked one")
+	}
+
+	broadcast := &Broadcast{ID: "b"}
+	d.Complete(tracked, broadcast)
+
+	if _, b, duplicate := d.Reserve("a", true); !duplicate || b != broadcast {
+		t.Errorf("expected a tracked duplicate to return the original broadcast, got %v", b)
+	}
+
+	failed, _, _ := d.Reserve("c", false)
+	d.Release("c", failed)
+
+	if _, _, duplicate := d.Reserve("c", false); duplicate {
+		t.Errorf("expected a released request not to count as duplicate")
+	}
+}
+
+func TestDeduplicatorConcurrent(t *testing.T) {
+	d := newDeduplicator(time.Minute)
+	broadcast := &Broadcast{ID: "b"}
+
+	var originals int
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			reservation, b, duplicate := d.Reserve("a", true)
+			if duplicate {
+				if b != broadcast {
+					t.Errorf("expected duplicates to return the original broadcast")
+				}
+
+				return
+			}
+
+			mutex.Lock()
+			originals++
+			mutex.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+			d.Complete(reservation, broadcast)
+		}()
+	}
+
+	wg.Wait()
+
+	if originals != 1 {
+		t.Errorf("expected exactly one request to be broadcast, got %d", originals)
+	}
+}
+
+func TestDeduplicatorDisabled(t *testing.T) {
+	d := newDeduplicator(0)
+
+	for i := 0; i < 2; i++ {
+		reservation, _, duplicate := d.Reserve("a", false)
+		if duplicate {
+			t.Fatalf("expected no duplicates with deduplication disabled")
+		}
+
+		d.Complete(reservation, nil)
+	}
+}
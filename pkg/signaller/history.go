@@ -0,0 +1,231 @@
+package signaller
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mittwald/kube-httpcache/pkg/watcher"
+)
+
+// maxHistoryEntries limits the number of signal requests kept for replaying
+const maxHistoryEntries = 10000
+
+// signalTemplate describes a signal request independently of the endpoints
+// it is delivered to
+type signalTemplate struct {
+	Method     string
+	RequestURI string
+	Host       string
+	Header     http.Header
+	Body       []byte
+	Ban        string
+	Received   time.Time
+
+	// RouteKey is the URL that is used for routing the signal to the
+	// frontends owning it, and Targets the names of the frontends that the
+	// signal is restricted to
+	RouteKey string
+	Targets  []string
+}
+
+// buildSignal creates the signal that delivers a signal request to the given
+// endpoint
+func (b *Signaller) buildSignal(t *signalTemplate, endpoint watcher.Endpoint) (Signal, error) {
+	if t.Ban != "" {
+		return Signal{Endpoint: endpoint, Ban: t.Ban}, nil
+	}
+
+	url := fmt.Sprintf("%s://%s:%s%s", b.EndpointScheme, endpoint.Host, endpoint.Port, t.RequestURI)
+	request, err := http.NewRequest(t.Method, url, bytes.NewReader(t.Body)) // Method eg (GET), URL of the endpoint. With those variables make a new http request
+	if err != nil {
+		return Signal{}, err
+	}
+
+	request.Header = t.Header.Clone()
+	request.Host = t.Host
+
+	return Signal{Request: request, Endpoint: endpoint}, nil
+}
+
+// signalHistory keeps the signal requests received within the TTL, so that
+// they can be replayed to frontends that appear later. It also remembers
+// when frontends disappeared, so that frontends that only flapped are not
+// sent the whole history again.
+type signalHistory struct {
+	ttl      time.Duration
+	entries  []*signalTemplate
+	departed map[string]time.Time
+	mutex    sync.Mutex
+}
+
+func newSignalHistory(ttl time.Duration) *signalHistory {
+	return &signalHistory{ttl: ttl, departed: make(map[string]time.Time)}
+}
+
+func (h *signalHistory) Add(t *signalTemplate) {
+	if h.ttl <= 0 {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.prune()
+	h.entries = append(h.entries, t)
+
+	if len(h.entries) > maxHistoryEntries {
+		h.entries = append(h.entries[:0:0], h.entries[len(h.entries)-maxHistoryEntries:]...)
+	}
+}
+
+// Depart records that endpoints have been removed
+func (h *signalHistory) Depart(endpoints watcher.EndpointList) {
+	if h.ttl <= 0 {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	now := time.Now()
+	for i := range endpoints {
+		h.departed[endpointKey(endpoints[i])] = now
+	}
+}
+
+// Missed returns the signal requests that an endpoint that has (re-)appeared
+// has missed: all requests received within the TTL for new endpoints, and
+// only those received since it disappeared for endpoints that were removed
+// within the TTL (like frontends that were temporarily not ready)
+func (h *signalHistory) Missed(endpoint watcher.Endpoint) []*signalTemplate {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.prune()
+
+	key := endpointKey(endpoint)
+	since, returned := h.departed[key]
+	delete(h.departed, key)
+
+	missed := make([]*signalTemplate, 0, len(h.entries))
+	for _, t := range h.entries {
+		if !returned || t.Received.After(since) {
+			missed = append(missed, t)
+		}
+	}
+
+	return missed
+}
+
+// prune removes expired entries. The caller needs to hold the mutex.
+func (h *signalHistory) prune() {
+	i := 0
+	for i < len(h.entries) && time.Since(h.entries[i].Received) > h.ttl {
+		i++
+	}
+
+	if i > 0 {
+		h.entries = append(h.entries[:0:0], h.entries[i:]...)
+	}
+
+	for key, departed := range h.departed {
+		if time.Since(departed) > h.ttl {
+			delete(h.departed, key)
+		}
+	}
+}
+
+// diffEndpoints returns the endpoints that have been added to and removed
+// from a list of endpoints
+func diffEndpoints(previous, current watcher.EndpointList) (added, removed watcher.EndpointList) {
+	for i := range current {
+		if !previous.Contains(&current[i]) {
+			added = append(added, current[i])
+		}
+	}
+
+	for i := range previous {
+		if !current.Contains(&previous[i]) {
+			removed = append(removed, previous[i])
+		}
+	}
+
+	return added, removed
+}
+
+// replay delivers the recent signal requests that newly observed endpoints
+// have missed and that are routed to them, given the current endpoints
+func (b *Signaller) replay(endpoints, current watcher.EndpointList) {
+	if b.history.ttl <= 0 {
+		return
+	}
+
+	for _, endpoint := range endpoints {
+		missed := b.history.Missed(endpoint)
+
+		signals := make([]Signal, 0, len(missed))
+		for _, t := range missed {
+			if !b.routedTo(t, endpoint, current) {
+				continue
+			}
+
+			signal, err := b.buildSignal(t, endpoint)
+			if err != nil {
+				logger.Warning("could not replay signal", "endpoint", endpointKey(endpoint), "error", err)
+				continue
+			}
+
+			signals = append(signals, signal)
+		}
+
+		if len(signals) == 0 {
+			continue
+		}
+
+		logger.Info("replaying recent signals to new endpoint", "endpoint", endpointKey(endpoint), "signals", len(signals))
+
+		if err := b.queue.Push(signals...); err != nil {
+			logger.Warning("could not replay signals", "endpoint", endpointKey(endpoint), "error", err)
+		}
+	}
+}
+
+// routedTo checks if a signal request is delivered to the endpoint, given
+// the current endpoints
+func (b *Signaller) routedTo(t *signalTemplate, endpoint watcher.Endpoint, endpoints watcher.EndpointList) bool {
+	if len(t.Targets) > 0 {
+		return containsString(t.Targets, endpoint.Name)
+	}
+
+	selected, err := b.selectEndpoints(t, endpoints)
+	return err == nil && selected.Contains(&endpoint)
+}
+
+// forget drops all pending signals to endpoints that have been removed, and
+// closes their admin connections
+func (b *Signaller) forget(endpoints watcher.EndpointList) {
+	b.history.Depart(endpoints)
+
+	removed := b.queue.Remove(func(s Signal) bool {
+		return endpoints.Contains(&s.Endpoint)
+	})
+
+	if len(removed) > 0 {
+		logger.Info("dropped pending signals to removed endpoints", "signals", len(removed))
+	}
+
+	for i := range removed {
+		if removed[i].Broadcast != nil {
+			removed[i].Broadcast.record(removed[i].Index, removed[i].Attempt, 0, errEndpointRemoved, true)
+		}
+	}
+
+	for i := range endpoints {
+		b.breaker.Forget(endpointKey(endpoints[i]))
+	}
+
+	b.closeAdminSessions(endpoints)
+}
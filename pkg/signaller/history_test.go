@@ -0,0 +1,83 @@
+package signaller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mittwald/kube-httpcache/pkg/watcher"
+)
+
+func TestSignalHistoryMissed(t *testing.T) {
+	h := newSignalHistory(time.Minute)
+	endpoint := watcher.Endpoint{Name: "pod-0", Host: "10.0.0.1", Port: "80"}
+
+	old := &signalTemplate{RequestURI: "/expired", Received: time.Now().Add(-2 * time.Minute)}
+	before := &signalTemplate{RequestURI: "/before", Received: time.Now().Add(-time.Second)}
+	h.entries = []*signalTemplate{old, before}
+
+	if missed := h.Missed(endpoint); len(missed) != 1 || missed[0] != before {
+		t.Fatalf("expected a new endpoint to miss all unexpired signals, got %d", len(missed))
+	}
+
+	h.Depart(watcher.EndpointList{endpoint})
+	time.Sleep(time.Millisecond)
+
+	during := &signalTemplate{RequestURI: "/during", Received: time.Now()}
+	h.Add(during)
+
+	if missed := h.Missed(endpoint); len(missed) != 1 || missed[0] != during {
+		t.Fatalf("expected a returning endpoint to miss only the signals received while it was gone, got %d", len(missed))
+	}
+
+	if missed := h.Missed(endpoint); len(missed) != 2 {
+		t.Fatalf("expected the departure to be forgotten once the endpoint has returned, got %d", len(missed))
+	}
+}
+
+func TestSignalHistoryForgetsDepartures(t *testing.T) {
+	h := newSignalHistory(time.Minute)
+	endpoint := watcher.Endpoint{Host: "10.0.0.1", Port: "80"}
+
+	h.departed[endpointKey(endpoint)] = time.Now().Add(-2 * time.Minute)
+	h.entries = []*signalTemplate{{RequestURI: "/a", Received: time.Now()}}
+
+	if missed := h.Missed(endpoint); len(missed) != 1 {
+		t.Fatalf("expected an endpoint that departed before the TTL to be treated as new, got %d", len(missed))
+	}
+}
+
+func TestReplayRouting(t *testing.T) {
+	endpoints := watcher.EndpointList{
+		{Name: "pod-0", Host: "10.0.0.1", Port: "80"},
+		{Name: "pod-1", Host: "10.0.0.2", Port: "80"},
+		{Name: "pod-2", Host: "10.0.0.3", Port: "80"},
+	}
+
+	b := NewSignaller("", 0, 1, 1, time.Second, 0, OverflowReject, 0, 0, time.Minute, 0, 0)
+	b.Routing = RoutingHash
+
+	urls := []string{"/a", "/b", "/c", "/d", "/e", "/f", "/g", "/h"}
+	for _, url := range urls {
+		b.history.Add(&signalTemplate{Method: "PURGE", RequestURI: url, RouteKey: url, Received: time.Now()})
+	}
+
+	b.history.Add(&signalTemplate{Method: "BAN", RequestURI: "/", Received: time.Now()})
+	b.history.Add(&signalTemplate{Method: "BAN", RequestURI: "/", Targets: []string{"pod-1"}, Received: time.Now()})
+
+	b.replay(endpoints, endpoints)
+
+	replayed := make(map[string]int)
+	for _, s := range b.queue.items {
+		replayed[s.signal.Endpoint.Name]++
+
+		if uri := s.signal.Request.URL.RequestURI(); uri != "/" && endpoints[hashOwner(endpoints, uri)].Name != s.signal.Endpoint.Name {
+			t.Errorf("replayed %s to %s, which does not own it", uri, s.signal.Endpoint.Name)
+		}
+	}
+
+	// every URL is replayed to its owner, the unrouted ban to all frontends
+	// and the targeted ban to pod-1 only
+	if n := len(b.queue.items); n != len(urls)+len(endpoints)+1 {
+		t.Errorf("expected %d replayed signals, got %d (%v)", len(urls)+len(endpoints)+1, n, replayed)
+	}
+}
@@ -0,0 +1,285 @@
+package signaller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InvalidatePath is the path of the structured invalidation API
+const InvalidatePath = "/api/v1/invalidate"
+
+// maxInvalidateBody limits the size of invalidation requests
+const maxInvalidateBody = 1 << 20
+
+// InvalidationItem describes content that should be removed from the cache.
+// Exactly one of URL, Regex and Tag may be set; if none of them is set, all
+// content of Host is invalidated.
+type InvalidationItem struct {
+	URL   string `json:"url,omitempty"`
+	Regex string `json:"regex,omitempty"`
+	Tag   string `json:"tag,omitempty"`
+	Host  string `json:"host,omitempty"`
+}
+
+// InvalidationRequest is the body accepted at InvalidatePath
+type InvalidationRequest struct {
+	Items []InvalidationItem `json:"items"`
+}
+
+// InvalidationResult describes what happened to a single item
+type InvalidationResult struct {
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	Broadcast *Broadcast `json:"broadcast,omitempty"`
+}
+
+const (
+	InvalidationAccepted  = "accepted"
+	InvalidationInvalid   = "invalid"
+	InvalidationForbidden = "forbidden"
+	InvalidationRejected  = "rejected"
+)
+
+// InvalidationResponse is returned at InvalidatePath, containing one result
+// per requested item
+type InvalidationResponse struct {
+	Results []InvalidationResult `json:"results"`
+}
+
+var validHost = regexp.MustCompile(`^[a-zA-Z0-9.:\[\]-]+$`)
+
+// validate checks an item for errors that can be detected without asking
+// Varnish (which is a best-effort check)
+func (item *InvalidationItem) validate() error {
+	set := 0
+	for _, v := range []string{item.URL, item.Regex, item.Tag} {
+		if v != "" {
+			set++
+		}
+	}
+
+	if set > 1 {
+		return fmt.Errorf("only one of 'url', 'regex' and 'tag' may be set")
+	}
+
+	if set == 0 && item.Host == "" {
+		return fmt.Errorf("one of 'url', 'regex', 'tag' or 'host' is required")
+	}
+
+	if item.Host != "" && !validHost.MatchString(item.Host) {
+		return fmt.Errorf("invalid host '%s'", item.Host)
+	}
+
+	if item.URL != "" && (!strings.HasPrefix(item.URL, "/") || strings.ContainsAny(item.URL, " \t\r\n")) {
+		return fmt.Errorf("url must be an absolute path without whitespace")
+	}
+
+	// regexes are not compiled here, since Varnish uses PCRE, which
+	// supports a different syntax than Go; invalid regexes are reported by
+	// the Varnish instances
+	if item.Regex != "" && strings.ContainsAny(item.Regex, "\r\n") {
+		return fmt.Errorf("regex must not contain line breaks")
+	}
+
+	if item.Tag != "" && strings.ContainsAny(item.Tag, " \t\r\n,") {
+		return fmt.Errorf("tag must not contain whitespace or commas")
+	}
+
+	return nil
+}
+
+// policyRequest returns the request that is checked against the policy for
+// an item, which is the request a caller would send to invalidate the item
+// directly: PURGE for URLs and tags, and BAN for regexes and hosts (matching
+// any path)
+func (item *InvalidationItem) policyRequest() *http.Request {
+	r := &http.Request{Method: "BAN", URL: &url.URL{Path: "/"}}
+
+	if item.URL != "" || item.Tag != "" {
+		r.Method = "PURGE"
+	}
+
+	if item.URL != "" {
+		r.URL.Path = item.URL
+	}
+
+	return r
+}
+
+// invalidationTemplate converts an invalidation item into a signal request. In admin
+// mode, items are translated into ban expressions; otherwise, URLs are
+// invalidated with PURGE requests, tags with PURGE requests carrying the tag
+// header (as expected by vmod-xkey) and hosts and regexes with BAN requests
+// carrying X-Host and X-Url-Regex headers.
+func (b *Signaller) invalidationTemplate(item *InvalidationItem, targets []string) *signalTemplate {
+	t := &signalTemplate{
+		Method:     "BAN",
+		RequestURI: "/",
+		Host:       item.Host,
+		Header:     make(http.Header),
+		Received:   time.Now(),
+		RouteKey:   item.URL,
+		Targets:    targets,
+	}
+
+	if item.Host != "" {
+		t.Header.Set("X-Host", item.Host)
+	}
+
+	if b.Mode == ModeAdmin {
+		var conditions []string
+
+		switch {
+		case item.URL != "":
+			conditions = append(conditions, "req.url == "+cliQuote(item.URL))
+		case item.Regex != "":
+			conditions = append(conditions, "req.url ~ "+cliQuote(item.Regex))
+		case item.Tag != "":
+			conditions = append(conditions, "obj.http."+b.TagHeader+" ~ "+cliQuote(`(^|[ ,])`+regexp.QuoteMeta(item.Tag)+`($|[ ,])`))
+		}
+
+		if item.Host != "" {
+			conditions = append(conditions, "req.http.host == "+cliQuote(item.Host))
+		}
+
+		t.Ban = strings.Join(conditions, " && ")
+		return t
+	}
+
+	switch {
+	case item.URL != "":
+		t.Method = "PURGE"
+		t.RequestURI = item.URL
+	case item.Tag != "":
+		t.Method = "PURGE"
+		t.Header.Set(b.TagHeader, item.Tag)
+	case item.Regex != "":
+		t.Header.Set("X-Url-Regex", item.Regex)
+	}
+
+	return t
+}
+
+func (b *Signaller) serveInvalidate(w http.ResponseWriter, r *http.Request, caller string) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxInvalidateBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req InvalidationRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Items) == 0 {
+		http.Error(w, "no items to invalidate", http.StatusBadRequest)
+		return
+	}
+
+	var wait time.Duration
+	if v := r.Header.Get(WaitHeader); v != "" {
+		wait, err = time.ParseDuration(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid %s header: %s", WaitHeader, err.Error()), http.StatusBadRequest)
+			return
+		}
+	}
+
+	track := wait > 0 || r.Header.Get(AsyncHeader) != ""
+
+	targets := parseTargets(r.Header.Get(TargetHeader))
+	if err := b.checkTargets(targets); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := InvalidationResponse{Results: make([]InvalidationResult, len(req.Items))}
+
+	// validate and authorize all items first, so that nothing is
+	// invalidated if the request contains an error
+	status := http.StatusOK
+	for i := range req.Items {
+		if err := req.Items[i].validate(); err != nil {
+			response.Results[i] = InvalidationResult{Status: InvalidationInvalid, Error: err.Error()}
+			status = http.StatusBadRequest
+			continue
+		}
+
+		if item := req.Items[i].policyRequest(); b.Policy != nil && !b.Policy.Allows(caller, item) {
+			logger.Warning("caller is not allowed to invalidate item", "caller", caller, "method", item.Method, "path", item.URL.Path)
+			response.Results[i] = InvalidationResult{Status: InvalidationForbidden, Error: "forbidden"}
+			if status == http.StatusOK {
+				status = http.StatusForbidden
+			}
+		}
+	}
+
+	if status != http.StatusOK {
+		writeInvalidationResponse(w, status, &response)
+		return
+	}
+
+	for i := range req.Items {
+		broadcast, err := b.submit(b.invalidationTemplate(&req.Items[i], targets), track)
+		if err != nil {
+			logger.Warning("rejecting invalidation", "url", req.Items[i].URL, "regex", req.Items[i].Regex, "tag", req.Items[i].Tag, "host", req.Items[i].Host, "error", err)
+			response.Results[i] = InvalidationResult{Status: InvalidationRejected, Error: err.Error()}
+
+			status = http.StatusServiceUnavailable
+			if err == ErrQueueFull {
+				w.Header().Set("Retry-After", strconv.Itoa(int(queueFullRetryAfter.Seconds())))
+			}
+
+			continue
+		}
+
+		response.Results[i] = InvalidationResult{Status: InvalidationAccepted, Broadcast: broadcast}
+	}
+
+	if wait > 0 {
+		deadline := time.Now().Add(wait)
+		for i := range response.Results {
+			if br := response.Results[i].Broadcast; br != nil {
+				br.Wait(time.Until(deadline))
+			}
+		}
+	}
+
+	writeInvalidationResponse(w, status, &response)
+}
+
+func writeInvalidationResponse(w http.ResponseWriter, status int, response *InvalidationResponse) {
+	// broadcasts may still be updated, so they are copied (one at a time)
+	// and encoded without holding any lock; deduplicated items may share
+	// the same broadcast
+	snapshots := make(map[*Broadcast]*Broadcast)
+	results := make([]InvalidationResult, len(response.Results))
+
+	for i, result := range response.Results {
+		if br := result.Broadcast; br != nil {
+			if _, ok := snapshots[br]; !ok {
+				snapshots[br] = br.snapshot()
+			}
+
+			result.Broadcast = snapshots[br]
+		}
+
+		results[i] = result
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(InvalidationResponse{Results: results}); err != nil {
+		logger.Warning("error while writing invalidation response", "error", err)
+	}
+}
@@ -0,0 +1,230 @@
+package signaller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInvalidationItemValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		item  InvalidationItem
+		valid bool
+	}{
+		{name: "url", item: InvalidationItem{URL: "/products/42"}, valid: true},
+		{name: "url with host", item: InvalidationItem{URL: "/products/42", Host: "www.example.com"}, valid: true},
+		{name: "regex without host", item: InvalidationItem{Regex: "^/categories/"}, valid: true},
+		{name: "pcre regex", item: InvalidationItem{Regex: `^/(?!admin)`}, valid: true},
+		{name: "tag", item: InvalidationItem{Tag: "product-42"}, valid: true},
+		{name: "host only", item: InvalidationItem{Host: "old.example.com"}, valid: true},
+		{name: "host with port", item: InvalidationItem{Host: "[::1]:8080"}, valid: true},
+		{name: "empty", item: InvalidationItem{}, valid: false},
+		{name: "url and tag", item: InvalidationItem{URL: "/a", Tag: "b"}, valid: false},
+		{name: "relative url", item: InvalidationItem{URL: "products/42"}, valid: false},
+		{name: "url with whitespace", item: InvalidationItem{URL: "/a b"}, valid: false},
+		{name: "regex with line break", item: InvalidationItem{Regex: "^/a\nb"}, valid: false},
+		{name: "tag with comma", item: InvalidationItem{Tag: "a,b"}, valid: false},
+		{name: "invalid host", item: InvalidationItem{Host: "example.com\" && req.url ~ ."}, valid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.item.validate(); (err == nil) != tt.valid {
+				t.Errorf("expected valid=%v, got error %v", tt.valid, err)
+			}
+		})
+	}
+}
+
+func TestInvalidationTemplate(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   string
+		item   InvalidationItem
+		method string
+		uri    string
+		header map[string]string
+		ban    string
+	}{
+		{
+			name:   "url in HTTP mode",
+			mode:   ModeHTTP,
+			item:   InvalidationItem{URL: "/a", Host: "example.com"},
+			method: "PURGE",
+			uri:    "/a",
+			header: map[string]string{"X-Host": "example.com"},
+		},
+		{
+			name:   "regex without host in HTTP mode",
+			mode:   ModeHTTP,
+			item:   InvalidationItem{Regex: "^/a"},
+			method: "BAN",
+			uri:    "/",
+			header: map[string]string{"X-Url-Regex": "^/a", "X-Host": ""},
+		},
+		{
+			name:   "tag in HTTP mode",
+			mode:   ModeHTTP,
+			item:   InvalidationItem{Tag: "t"},
+			method: "PURGE",
+			uri:    "/",
+			header: map[string]string{"Xkey": "t"},
+		},
+		{
+			name: "url with host in admin mode",
+			mode: ModeAdmin,
+			item: InvalidationItem{URL: "/a", Host: "example.com"},
+			ban:  `req.url == "/a" && req.http.host == "example.com"`,
+		},
+		{
+			name: "regex in admin mode",
+			mode: ModeAdmin,
+			item: InvalidationItem{Regex: `^/a"b`},
+			ban:  `req.url ~ "^/a\"b"`,
+		},
+		{
+			name: "tag in admin mode",
+			mode: ModeAdmin,
+			item: InvalidationItem{Tag: "a.b"},
+			ban:  `obj.http.xkey ~ "(^|[ ,])a\\.b($|[ ,])"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := Signaller{Mode: tt.mode, TagHeader: "xkey"}
+			template := b.invalidationTemplate(&tt.item, nil)
+
+			if tt.mode == ModeAdmin {
+				if template.Ban != tt.ban {
+					t.Errorf("expected ban %q, got %q", tt.ban, template.Ban)
+				}
+
+				if _, err := parseBanExpression(template.Ban); err != nil {
+					t.Errorf("expected a valid ban expression, got %s", err)
+				}
+
+				return
+			}
+
+			if template.Method != tt.method || template.RequestURI != tt.uri {
+				t.Errorf("expected %s %s, got %s %s", tt.method, tt.uri, template.Method, template.RequestURI)
+			}
+
+			for name, value := range tt.header {
+				if got := template.Header.Get(name); got != value {
+					t.Errorf("expected header %s=%q, got %q", name, value, got)
+				}
+			}
+		})
+	}
+}
+
+func TestServeInvalidateChecksPolicy(t *testing.T) {
+	b := NewSignaller("", 0, 1, 1, time.Second, 0, OverflowReject, 0, 0, 0, 0, 0)
+	b.Policy = &Policy{Rules: []PolicyRule{
+		{Caller: "cms", Methods: []string{"PURGE"}, PathPrefixes: []string{"/products"}},
+	}}
+
+	tests := []struct {
+		name     string
+		caller   string
+		items    string
+		status   int
+		statuses []string
+	}{
+		{"allowed url", "cms", `[{"url": "/products/42"}]`, http.StatusOK, []string{InvalidationAccepted}},
+		{"url outside prefix", "cms", `[{"url": "/products/42"}, {"url": "/users/42"}]`, http.StatusForbidden, []string{"", InvalidationForbidden}},
+		{"regex needs BAN", "cms", `[{"regex": "^/products/"}]`, http.StatusForbidden, []string{InvalidationForbidden}},
+		{"tag matches any path", "cms", `[{"tag": "product-42"}]`, http.StatusForbidden, []string{InvalidationForbidden}},
+		{"unknown caller", "someone", `[{"url": "/products/42"}]`, http.StatusForbidden, []string{InvalidationForbidden}},
+		{"invalid items take precedence", "cms", `[{"url": "users"}, {"url": "/users/42"}]`, http.StatusBadRequest, []string{InvalidationInvalid, InvalidationForbidden}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, InvalidatePath, strings.NewReader(`{"items": `+tt.items+`}`))
+			rec := httptest.NewRecorder()
+
+			b.serveInvalidate(rec, r, tt.caller)
+
+			if rec.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+
+			var response InvalidationResponse
+			if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+
+			for i := range tt.statuses {
+				if response.Results[i].Status != tt.statuses[i] {
+					t.Errorf("expected item %d to be %q, got %q", i, tt.statuses[i], response.Results[i].Status)
+				}
+			}
+		})
+	}
+}
+
+// slowResponseWriter delays writes, like a client reading the response slowly
+type slowResponseWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w slowResponseWriter) Write(p []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestWriteInvalidationResponseConcurrently(t *testing.T) {
+	a, err := newBroadcast(testEndpoints("a1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := newBroadcast(testEndpoints("b2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// deduplicated items may share broadcasts in any order
+	responses := []*InvalidationResponse{
+		{Results: []InvalidationResult{{Status: InvalidationAccepted, Broadcast: a}, {Status: InvalidationAccepted, Broadcast: b}}},
+		{Results: []InvalidationResult{{Status: InvalidationAccepted, Broadcast: b}, {Status: InvalidationAccepted, Broadcast: a}}},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		for _, response := range responses {
+			wg.Add(1)
+			go func(response *InvalidationResponse) {
+				defer wg.Done()
+				writeInvalidationResponse(slowResponseWriter{httptest.NewRecorder()}, http.StatusOK, response)
+			}(response)
+		}
+
+		wg.Add(1)
+		go func(attempts int) {
+			defer wg.Done()
+			a.record(0, attempts, http.StatusServiceUnavailable, nil, false)
+			b.record(0, attempts, http.StatusServiceUnavailable, nil, false)
+		}(i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("writing responses that share broadcasts did not complete")
+	}
+}
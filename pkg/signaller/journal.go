@@ -0,0 +1,183 @@
+package signaller
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mittwald/kube-httpcache/pkg/watcher"
+)
+
+// journalWriteInterval defines how often queue contents are written to disk
+const journalWriteInterval = time.Second
+
+type journalEntry struct {
+	ID        uint64           `json:"id"`
+	Method    string           `json:"method,omitempty"`
+	URL       string           `json:"url,omitempty"`
+	Host      string           `json:"host,omitempty"`
+	Header    http.Header      `json:"header,omitempty"`
+	Body      []byte           `json:"body,omitempty"`
+	Endpoint  watcher.Endpoint `json:"endpoint"`
+	Ban       string           `json:"ban,omitempty"`
+	Attempt   int              `json:"attempt"`
+	NotBefore time.Time        `json:"notBefore"`
+}
+
+// journal persists the contents of the signal queue on disk, so that queued
+// signals survive restarts. Snapshots are written periodically and
+// atomically (by renaming a temporary file).
+type journal struct {
+	filename string
+	snapshot []queuedSignal
+	dirty    bool
+	mutex    sync.Mutex
+}
+
+func newJournal(filename string) *journal {
+	return &journal{filename: filename}
+}
+
+// load reads the signals from a previously written journal
+func (j *journal) load() ([]queuedSignal, error) {
+	contents, err := ioutil.ReadFile(j.filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []journalEntry
+	if err := json.Unmarshal(contents, &entries); err != nil {
+		return nil, err
+	}
+
+	items := make([]queuedSignal, 0, len(entries))
+	for i := range entries {
+		e := &entries[i]
+		signal := Signal{
+			Endpoint: e.Endpoint,
+			Ban:      e.Ban,
+			Attempt:  e.Attempt,
+			id:       e.ID,
+		}
+
+		if e.Ban == "" {
+			request, err := http.NewRequest(e.Method, e.URL, bytes.NewReader(e.Body))
+			if err != nil {
+				logger.Warning("skipping invalid journal entry", "id", e.ID, "error", err)
+				continue
+			}
+
+			request.Header = e.Header
+			request.Host = e.Host
+			signal.Request = request
+		}
+
+		items = append(items, queuedSignal{signal: signal, notBefore: e.NotBefore})
+	}
+
+	return items, nil
+}
+
+func (j *journal) update(snapshot []queuedSignal) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.snapshot = snapshot
+	j.dirty = true
+}
+
+// run writes the journal whenever the queue contents have changed
+func (j *journal) run() {
+	for range time.Tick(journalWriteInterval) {
+		if err := j.flush(); err != nil {
+			logger.Warning("error while writing signal journal", "file", j.filename, "error", err)
+
+			j.mutex.Lock()
+			j.dirty = true
+			j.mutex.Unlock()
+		}
+	}
+}
+
+func (j *journal) flush() error {
+	j.mutex.Lock()
+	snapshot := j.snapshot
+	dirty := j.dirty
+	j.dirty = false
+	j.mutex.Unlock()
+
+	if !dirty {
+		return nil
+	}
+
+	entries := make([]journalEntry, 0, len(snapshot))
+	for i := range snapshot {
+		s := &snapshot[i].signal
+		e := journalEntry{
+			ID:        s.id,
+			Endpoint:  s.Endpoint,
+			Ban:       s.Ban,
+			Attempt:   s.Attempt,
+			NotBefore: snapshot[i].notBefore,
+		}
+
+		if s.Request != nil {
+			e.Method = s.Request.Method
+			e.URL = s.Request.URL.String()
+			e.Host = s.Request.Host
+			e.Header = s.Request.Header
+
+			if s.Request.GetBody != nil {
+				body, err := s.Request.GetBody()
+				if err != nil {
+					return err
+				}
+
+				e.Body, err = ioutil.ReadAll(body)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		entries = append(entries, e)
+	}
+
+	contents, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(j.filename), filepath.Base(j.filename)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), j.filename)
+}
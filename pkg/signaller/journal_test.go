@@ -0,0 +1,76 @@
+package signaller
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mittwald/kube-httpcache/pkg/watcher"
+)
+
+func TestJournalRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	request, err := http.NewRequest("PURGE", "http://10.0.0.1:80/path?q=1", bytes.NewReader([]byte("body")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request.Host = "www.example.com"
+	request.Header.Set("X-Test", "yes")
+
+	notBefore := time.Now().Add(time.Minute).Truncate(time.Second)
+	endpoint := watcher.Endpoint{Name: "pod-0", Host: "10.0.0.1", Port: "80"}
+
+	j := newJournal(filepath.Join(dir, "journal.json"))
+	j.update([]queuedSignal{
+		{signal: Signal{Request: request, Endpoint: endpoint, Attempt: 2, id: 7}, notBefore: notBefore},
+		{signal: Signal{Ban: `req.url == "/a"`, Endpoint: endpoint, id: 8}},
+	})
+
+	if err := j.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := newJournal(j.filename).load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 2 {
+		t.Fatalf("expected 2 signals, got %d", len(items))
+	}
+
+	s := items[0].signal
+	if s.id != 7 || s.Attempt != 2 || s.Endpoint != endpoint || !items[0].notBefore.Equal(notBefore) {
+		t.Errorf("unexpected signal %+v (not before %s)", s, items[0].notBefore)
+	}
+
+	if s.Request.Method != "PURGE" || s.Request.URL.String() != "http://10.0.0.1:80/path?q=1" || s.Request.Host != "www.example.com" || s.Request.Header.Get("X-Test") != "yes" {
+		t.Errorf("unexpected request %+v", s.Request)
+	}
+
+	body, err := ioutil.ReadAll(s.Request.Body)
+	if err != nil || string(body) != "body" {
+		t.Errorf("expected body %q, got %q (%v)", "body", body, err)
+	}
+
+	if b := items[1].signal; b.id != 8 || b.Ban != `req.url == "/a"` || b.Request != nil {
+		t.Errorf("unexpected ban signal %+v", b)
+	}
+}
+
+func TestJournalLoadMissingFile(t *testing.T) {
+	items, err := newJournal(filepath.Join(os.TempDir(), "does-not-exist", "journal.json")).load()
+	if err != nil || len(items) != 0 {
+		t.Errorf("expected no signals and no error, got %d signals and %v", len(items), err)
+	}
+}
@@ -0,0 +1,394 @@
+package signaller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	coordinationclient "k8s.io/client-go/kubernetes/typed/coordination/v1"
+)
+
+const (
+	// LeaderPath reports the current leader of the signallers
+	LeaderPath = "/api/v1/leader"
+
+	// ForwardedHeader marks requests that have been forwarded to the leader
+	// by another signaller (identified by its pod name); these are never
+	// forwarded again. The header is only accepted from the frontend pod
+	// with that name.
+	ForwardedHeader = "X-Signaller-Forwarded"
+
+	// leaseDuration is the time after which the lease of a leader that
+	// stopped renewing it can be taken over by another signaller
+	leaseDuration = 15 * time.Second
+
+	// renewDeadline is the time after which a leader that fails to renew
+	// its lease steps down
+	renewDeadline = 10 * time.Second
+
+	// retryPeriod is the interval in which the lease is renewed or
+	// acquisition is attempted
+	retryPeriod = 2 * time.Second
+)
+
+// LeaderStatus is returned at LeaderPath
+type LeaderStatus struct {
+	Identity string `json:"identity"`
+	Leader   string `json:"leader"`
+	IsLeader bool   `json:"isLeader"`
+}
+
+// EnableLeaderElection makes the signaller take part in the election of a
+// leader with the given identity, which needs to be the name of the pod, so
+// that followers can find the address of the leader among the frontends. It
+// needs to be called before Run; signal requests are rejected until the
+// first round of the election (see RunLeaderElection) has completed, since
+// it is not known before whether they need to be forwarded.
+func (b *Signaller) EnableLeaderElection(identity string) {
+	b.leaderMutex.Lock()
+	defer b.leaderMutex.Unlock()
+
+	b.identity = identity
+	b.electionEnabled = true
+	b.electionPending = true
+}
+
+// RunLeaderElection takes part in the election of a leader among all
+// signallers using the Lease with the given name. Until ctx is done, all
+// signal requests received by followers are forwarded to the leader.
+func (b *Signaller) RunLeaderElection(ctx context.Context, client kubernetes.Interface, namespace, name string) {
+	identity := b.LeaderStatus().Identity
+
+	elector := &leaseElector{
+		leases:   client.CoordinationV1().Leases(namespace),
+		name:     name,
+		identity: identity,
+	}
+
+	var lastRenewal time.Time
+
+	for {
+		leader, err := elector.tryAcquire()
+		now := time.Now()
+
+		switch {
+		case err == nil:
+			if leader == identity {
+				lastRenewal = now
+			}
+
+			b.setLeader(leader)
+
+		case b.LeaderStatus().IsLeader && now.Sub(lastRenewal) > renewDeadline:
+			logger.Error("could not renew signaller lease; stepping down", "lease", namespace+"/"+name, "error", err)
+			b.setLeader("")
+
+		default:
+			logger.Warning("error while updating signaller lease", "lease", namespace+"/"+name, "error", err)
+		}
+
+		// if the first round failed, signal requests are processed locally,
+		// like when the leader cannot be reached
+		b.leaderMutex.Lock()
+		b.electionPending = false
+		b.leaderMutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			if b.LeaderStatus().Leader == identity {
+				releaseLease(elector.leases, name, identity)
+			}
+
+			return
+		case <-time.After(retryPeriod):
+		}
+	}
+}
+
+// leaseElector acquires and renews a lease. Like the leader election of
+// client-go, it does not compare the renew time of the lease with the local
+// clock (which may differ from the holder's clock), but considers the lease
+// expired if it has not been modified for its duration since it was last
+// observed to change.
+type leaseElector struct {
+	leases   coordinationclient.LeaseInterface
+	name     string
+	identity string
+
+	observedVersion string
+	observedTime    time.Time
+}
+
+// observe records when a modification of the lease was first seen, and
+// returns whether the lease has expired
+func (e *leaseElector) observe(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.ResourceVersion != e.observedVersion {
+		e.observedVersion = lease.ResourceVersion
+		e.observedTime = now
+	}
+
+	duration := leaseDuration
+	if lease.Spec.LeaseDurationSeconds != nil {
+		duration = time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	}
+
+	return e.observedTime.Add(duration).Before(now)
+}
+
+// tryAcquire acquires or renews the lease if it is held by the elector's
+// identity or has expired, and returns the current holder of the lease
+func (e *leaseElector) tryAcquire() (string, error) {
+	now := metav1.NewMicroTime(time.Now())
+	duration := int32(leaseDuration.Seconds())
+	identity := e.identity
+
+	lease, err := e.leases.Get(e.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		var transitions int32
+
+		_, err = e.leases.Create(&coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: e.name},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &identity,
+				LeaseDurationSeconds: &duration,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+				LeaseTransitions:     &transitions,
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+
+		return identity, nil
+	} else if err != nil {
+		return "", err
+	}
+
+	holder := ""
+	if lease.Spec.HolderIdentity != nil {
+		holder = *lease.Spec.HolderIdentity
+	}
+
+	expired := e.observe(lease, now.Time)
+
+	if holder != identity && holder != "" && !expired {
+		return holder, nil
+	}
+
+	if holder != identity {
+		var transitions int32
+		if lease.Spec.LeaseTransitions != nil {
+			transitions = *lease.Spec.LeaseTransitions + 1
+		}
+
+		lease.Spec.HolderIdentity = &identity
+		lease.Spec.AcquireTime = &now
+		lease.Spec.LeaseTransitions = &transitions
+	}
+
+	lease.Spec.LeaseDurationSeconds = &duration
+	lease.Spec.RenewTime = &now
+
+	// updates fail with a conflict if another signaller has modified the
+	// lease in the meantime
+	updated, err := e.leases.Update(lease)
+	if err != nil {
+		return "", err
+	}
+
+	e.observedVersion = updated.ResourceVersion
+	e.observedTime = now.Time
+
+	return identity, nil
+}
+
+// releaseLease gives up the lease, so that another signaller can take over
+// without waiting for the lease to expire
+func releaseLease(leases coordinationclient.LeaseInterface, name, identity string) {
+	lease, err := leases.Get(name, metav1.GetOptions{})
+	if err != nil || lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != identity {
+		return
+	}
+
+	lease.Spec.HolderIdentity = nil
+	lease.Spec.RenewTime = nil
+
+	if _, err := leases.Update(lease); err != nil {
+		logger.Warning("could not release signaller lease", "lease", name, "error", err)
+	}
+}
+
+func (b *Signaller) setLeader(leader string) {
+	b.leaderMutex.Lock()
+	defer b.leaderMutex.Unlock()
+
+	if leader == b.leader {
+		return
+	}
+
+	switch {
+	case leader == b.identity:
+		logger.Info("became leader of the signallers")
+	case b.leader == b.identity:
+		logger.Info("stopped leading the signallers")
+	}
+
+	if leader != "" {
+		logger.Info("observed new signaller leader", "leader", leader)
+	}
+
+	b.leader = leader
+}
+
+// LeaderStatus returns the current state of the leader election
+func (b *Signaller) LeaderStatus() LeaderStatus {
+	b.leaderMutex.RLock()
+	defer b.leaderMutex.RUnlock()
+
+	return LeaderStatus{
+		Identity: b.identity,
+		Leader:   b.leader,
+		IsLeader: !b.electionEnabled || (b.leader != "" && b.leader == b.identity),
+	}
+}
+
+// rejectWhileElectionPending responds with 503 if the first round of the
+// leader election has not completed yet
+func (b *Signaller) rejectWhileElectionPending(w http.ResponseWriter) bool {
+	b.leaderMutex.RLock()
+	pending := b.electionPending
+	b.leaderMutex.RUnlock()
+
+	if !pending {
+		return false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryPeriod.Seconds())))
+	http.Error(w, "signaller leader election in progress", http.StatusServiceUnavailable)
+
+	return true
+}
+
+// leaderAddress returns the address of the leader's signaller if another
+// signaller is the leader
+func (b *Signaller) leaderAddress() (string, bool) {
+	status := b.LeaderStatus()
+	if status.IsLeader || status.Leader == "" {
+		return "", false
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for i := range b.endpoints.Endpoints {
+		if b.endpoints.Endpoints[i].Name == status.Leader {
+			return net.JoinHostPort(b.endpoints.Endpoints[i].Host, strconv.Itoa(b.Port)), true
+		}
+	}
+
+	return "", false
+}
+
+// forwardedByPeer checks if a request has been forwarded by another
+// signaller, i.e. if the ForwardedHeader names a frontend pod whose address
+// is the remote address of the request
+func (b *Signaller) forwardedByPeer(r *http.Request) bool {
+	peer := r.Header.Get(ForwardedHeader)
+	if peer == "" {
+		return false
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for i := range b.endpoints.Endpoints {
+		if b.endpoints.Endpoints[i].Name == peer {
+			return net.ParseIP(b.endpoints.Endpoints[i].Host).Equal(net.ParseIP(host))
+		}
+	}
+
+	return false
+}
+
+// forwardToLeader forwards a signal request to the leader (together with the
+// caller's original credentials), if this signaller is a follower. It
+// returns false if the request needs to be processed locally.
+func (b *Signaller) forwardToLeader(w http.ResponseWriter, r *http.Request, credentials string) bool {
+	if r.Header.Get(ForwardedHeader) != "" {
+		return false
+	}
+
+	address, ok := b.leaderAddress()
+	if !ok {
+		return false
+	}
+
+	scheme := "http"
+	if b.TLSCertFile != "" {
+		scheme = "https"
+	}
+
+	failed := false
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = scheme
+			req.URL.Host = address
+			req.Header.Set(ForwardedHeader, b.LeaderStatus().Identity)
+		},
+		Transport: b.forwardTransport,
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			logger.Warning("could not forward signal request to leader", "leader", address, "error", err)
+			failed = true
+		},
+	}
+
+	// the body needs to be kept in case the request has to be processed
+	// locally after forwarding failed
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return true
+	}
+
+	forwarded := r.Clone(r.Context())
+	forwarded.Body = ioutil.NopCloser(bytes.NewReader(body))
+	forwarded.ContentLength = int64(len(body))
+
+	if credentials != "" {
+		forwarded.Header.Set("Authorization", credentials)
+	}
+
+	logger.Debug("forwarding signal request to leader", "method", r.Method, "uri", r.RequestURI, "leader", address)
+	proxy.ServeHTTP(w, forwarded)
+
+	if failed {
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return false
+	}
+
+	return true
+}
+
+func (b *Signaller) serveLeader(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(b.LeaderStatus()); err != nil {
+		logger.Warning("error while writing leader status", "error", err)
+	}
+}
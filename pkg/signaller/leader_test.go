@@ -0,0 +1,128 @@
+package signaller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/mittwald/kube-httpcache/pkg/watcher"
+)
+
+func TestLeaseElectorObserve(t *testing.T) {
+	duration := int32(15)
+	start := time.Now()
+
+	// the renew time lies far in the past, as seen by a clock that is off
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{ResourceVersion: "1"},
+		Spec: coordinationv1.LeaseSpec{
+			LeaseDurationSeconds: &duration,
+			RenewTime:            &metav1.MicroTime{Time: start.Add(-time.Hour)},
+		},
+	}
+
+	e := &leaseElector{}
+
+	tests := []struct {
+		version string
+		after   time.Duration
+		expired bool
+	}{
+		{"1", 0, false},
+		{"1", 10 * time.Second, false},
+		{"2", 20 * time.Second, false},
+		{"2", 30 * time.Second, false},
+		{"2", 36 * time.Second, true},
+	}
+
+	for _, test := range tests {
+		lease.ResourceVersion = test.version
+
+		if expired := e.observe(lease, start.Add(test.after)); expired != test.expired {
+			t.Errorf("expected lease version %s to be expired=%t after %s, got %t", test.version, test.expired, test.after, expired)
+		}
+	}
+}
+
+func TestForwardedByPeer(t *testing.T) {
+	b := Signaller{endpoints: watcher.NewEndpointConfig()}
+	b.endpoints.Endpoints = watcher.EndpointList{
+		{Name: "pod-0", Host: "10.0.0.1", Port: "80"},
+		{Name: "pod-1", Host: "10.0.0.2", Port: "80"},
+	}
+
+	tests := []struct {
+		name      string
+		header    string
+		remote    string
+		forwarded bool
+	}{
+		{"peer", "pod-0", "10.0.0.1:4711", true},
+		{"no header", "", "10.0.0.1:4711", false},
+		{"other peer's address", "pod-1", "10.0.0.1:4711", false},
+		{"client", "pod-0", "192.168.0.1:4711", false},
+		{"unknown peer", "pod-9", "10.0.0.1:4711", false},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("BAN", "/", nil)
+		r.RemoteAddr = test.remote
+
+		if test.header != "" {
+			r.Header.Set(ForwardedHeader, test.header)
+		}
+
+		if forwarded := b.forwardedByPeer(r); forwarded != test.forwarded {
+			t.Errorf("%s: expected forwarded=%t, got %t", test.name, test.forwarded, forwarded)
+		}
+	}
+}
+
+func TestServeHTTPWhileElectionPending(t *testing.T) {
+	b := NewSignaller("", 0, 1, 1, time.Second, 0, OverflowReject, 0, 0, 0, 0, 0)
+	b.EnableLeaderElection("pod-0")
+	b.endpoints.Endpoints = watcher.EndpointList{
+		{Name: "pod-1", Host: "10.0.0.2", Port: "80"},
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		peer    string
+		pending bool
+		status  int
+	}{
+		{"signal while pending", "PURGE", "/a", "", true, http.StatusServiceUnavailable},
+		{"broadcast report while pending", http.MethodGet, BroadcastsPath + "unknown", "", true, http.StatusServiceUnavailable},
+		{"leader status while pending", http.MethodGet, LeaderPath, "", true, http.StatusOK},
+		{"forwarded signal while pending", "PURGE", "/a", "pod-1", true, http.StatusOK},
+		{"signal after the first round", "PURGE", "/b", "", false, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		b.electionPending = test.pending
+
+		r := httptest.NewRequest(test.method, test.target, nil)
+		r.RemoteAddr = "10.0.0.2:4711"
+
+		if test.peer != "" {
+			r.Header.Set(ForwardedHeader, test.peer)
+		}
+
+		rec := httptest.NewRecorder()
+		b.ServeHTTP(rec, r)
+
+		if rec.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.status, rec.Code)
+		}
+
+		if test.status == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: expected a Retry-After header", test.name)
+		}
+	}
+}
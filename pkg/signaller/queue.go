@@ -0,0 +1,246 @@
+package signaller
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// OverflowReject rejects new signal requests while the queue is full
+	OverflowReject = "reject"
+
+	// OverflowDropOldest discards the oldest queued signals to make room for
+	// new ones
+	OverflowDropOldest = "drop-oldest"
+)
+
+// ErrQueueFull is returned when signals cannot be queued because the queue
+// has reached its capacity
+var ErrQueueFull = errors.New("signal queue is full")
+
+// errEndpointRemoved is reported for signals that were dropped because their
+// endpoint disappeared
+var errEndpointRemoved = errors.New("endpoint has been removed")
+
+type queuedSignal struct {
+	signal    Signal
+	notBefore time.Time
+}
+
+// signalQueue is a bounded FIFO queue of signals. Signals can be scheduled
+// for later delivery (which is used for retries), and queue contents are
+// optionally persisted in a journal.
+type signalQueue struct {
+	capacity int
+	overflow string
+	journal  *journal
+
+	items    []queuedSignal
+	inFlight map[uint64]Signal
+	nextID   uint64
+	popSeq   uint64
+	dropped  uint64
+	notify   chan struct{}
+	mutex    sync.Mutex
+}
+
+func newSignalQueue(capacity int, overflow string) *signalQueue {
+	return &signalQueue{
+		capacity: capacity,
+		overflow: overflow,
+		inFlight: make(map[uint64]Signal),
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+// Push adds signals to the queue. Depending on the overflow policy, either
+// all signals are rejected, or the oldest queued signals are dropped if the
+// queue does not have enough room left.
+func (q *signalQueue) Push(signals ...Signal) error {
+	return q.push(time.Time{}, false, signals...)
+}
+
+// Requeue adds a signal returned by Pop to the queue again, to be delivered
+// not before the given point in time. The signal keeps its ID and replaces
+// its in-flight entry, so that a journal snapshot never contains it twice.
+func (q *signalQueue) Requeue(notBefore time.Time, signal Signal) error {
+	return q.push(notBefore, true, signal)
+}
+
+// push adds signals to the queue. With keepID, the signals keep their IDs
+// (which is used for retries and for restoring signals from the journal),
+// and are no longer considered in flight.
+func (q *signalQueue) push(notBefore time.Time, keepID bool, signals ...Signal) error {
+	q.mutex.Lock()
+
+	var dropped []Signal
+
+	if q.capacity > 0 && len(q.items)+len(signals) > q.capacity {
+		if q.overflow != OverflowDropOldest || len(signals) > q.capacity {
+			q.mutex.Unlock()
+			return ErrQueueFull
+		}
+
+		n := len(q.items) + len(signals) - q.capacity
+		for i := 0; i < n; i++ {
+			dropped = append(dropped, q.items[i].signal)
+		}
+
+		q.items = append(q.items[:0:0], q.items[n:]...)
+		q.dropped += uint64(n)
+	}
+
+	for i := range signals {
+		if !keepID {
+			q.nextID++
+			signals[i].id = q.nextID
+		} else {
+			q.done(signals[i])
+
+			if signals[i].id > q.nextID {
+				q.nextID = signals[i].id
+			}
+		}
+
+		q.items = append(q.items, queuedSignal{signal: signals[i], notBefore: notBefore})
+	}
+
+	q.persist()
+	q.mutex.Unlock()
+
+	for i := range dropped {
+		logger.Warning("dropped signal due to queue overflow", "endpoint", endpointKey(dropped[i].Endpoint), "attempt", dropped[i].Attempt)
+
+		if dropped[i].Broadcast != nil {
+			dropped[i].Broadcast.record(dropped[i].Index, dropped[i].Attempt, 0, ErrQueueFull, true)
+		}
+	}
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Pop blocks until a signal is due for delivery and removes it from the
+// queue. Done needs to be called once the signal has been processed.
+func (q *signalQueue) Pop() Signal {
+	for {
+		q.mutex.Lock()
+
+		now := time.Now()
+		var next time.Time
+
+		for i := range q.items {
+			item := q.items[i]
+
+			if !item.notBefore.After(now) {
+				q.popSeq++
+				item.signal.popped = q.popSeq
+
+				q.items = append(q.items[:i], q.items[i+1:]...)
+				q.inFlight[item.signal.id] = item.signal
+				q.mutex.Unlock()
+
+				// wake up other workers in case more signals are due
+				select {
+				case q.notify <- struct{}{}:
+				default:
+				}
+
+				return item.signal
+			}
+
+			if next.IsZero() || item.notBefore.Before(next) {
+				next = item.notBefore
+			}
+		}
+
+		q.mutex.Unlock()
+
+		if next.IsZero() {
+			<-q.notify
+			continue
+		}
+
+		t := time.NewTimer(time.Until(next))
+		select {
+		case <-q.notify:
+		case <-t.C:
+		}
+		t.Stop()
+	}
+}
+
+// Done marks a signal returned by Pop as processed
+func (q *signalQueue) Done(signal Signal) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.done(signal)
+	q.persist()
+}
+
+// done removes a signal from the signals in flight, unless it has been
+// requeued and popped again by another worker in the meantime. The caller
+// needs to hold the queue's mutex.
+func (q *signalQueue) done(signal Signal) {
+	if s, ok := q.inFlight[signal.id]; ok && s.popped == signal.popped {
+		delete(q.inFlight, signal.id)
+	}
+}
+
+// Remove removes all queued signals that match the predicate, and returns
+// the removed signals
+func (q *signalQueue) Remove(match func(Signal) bool) []Signal {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	var removed []Signal
+	items := q.items[:0]
+
+	for i := range q.items {
+		if match(q.items[i].signal) {
+			removed = append(removed, q.items[i].signal)
+			continue
+		}
+
+		items = append(items, q.items[i])
+	}
+
+	q.items = items
+
+	if len(removed) > 0 {
+		q.persist()
+	}
+
+	return removed
+}
+
+// Len returns the number of queued signals (excluding signals in flight)
+func (q *signalQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return len(q.items)
+}
+
+// persist hands the current queue contents to the journal (if any). The
+// caller needs to hold the queue's mutex.
+func (q *signalQueue) persist() {
+	if q.journal == nil {
+		return
+	}
+
+	snapshot := make([]queuedSignal, 0, len(q.inFlight)+len(q.items))
+	for _, s := range q.inFlight {
+		snapshot = append(snapshot, queuedSignal{signal: s})
+	}
+
+	snapshot = append(snapshot, q.items...)
+
+	q.journal.update(snapshot)
+}
@@ -0,0 +1,138 @@
+package signaller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mittwald/kube-httpcache/pkg/watcher"
+)
+
+func testSignals(hosts ...string) []Signal {
+	signals := make([]Signal, len(hosts))
+	for i, host := range hosts {
+		signals[i] = Signal{Endpoint: watcher.Endpoint{Host: host, Port: "80"}}
+	}
+
+	return signals
+}
+
+func queuedHosts(q *signalQueue) []string {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	hosts := make([]string, len(q.items))
+	for i := range q.items {
+		hosts[i] = q.items[i].signal.Endpoint.Host
+	}
+
+	return hosts
+}
+
+func TestSignalQueueOverflow(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		overflow string
+		pushes   [][]string
+		errors   []error
+		queued   []string
+		dropped  uint64
+	}{
+		{
+			name:     "unbounded",
+			capacity: 0,
+			overflow: OverflowReject,
+			pushes:   [][]string{{"a", "b"}, {"c", "d"}},
+			errors:   []error{nil, nil},
+			queued:   []string{"a", "b", "c", "d"},
+		},
+		{
+			name:     "reject when full",
+			capacity: 3,
+			overflow: OverflowReject,
+			pushes:   [][]string{{"a", "b"}, {"c", "d"}, {"e"}},
+			errors:   []error{nil, ErrQueueFull, nil},
+			queued:   []string{"a", "b", "e"},
+		},
+		{
+			name:     "drop oldest when full",
+			capacity: 3,
+			overflow: OverflowDropOldest,
+			pushes:   [][]string{{"a", "b"}, {"c", "d"}, {"e"}},
+			errors:   []error{nil, nil, nil},
+			queued:   []string{"c", "d", "e"},
+			dropped:  2,
+		},
+		{
+			name:     "drop oldest rejects pushes larger than the capacity",
+			capacity: 2,
+			overflow: OverflowDropOldest,
+			pushes:   [][]string{{"a"}, {"b", "c", "d"}},
+			errors:   []error{nil, ErrQueueFull},
+			queued:   []string{"a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newSignalQueue(tt.capacity, tt.overflow)
+
+			for i, hosts := range tt.pushes {
+				if err := q.Push(testSignals(hosts...)...); err != tt.errors[i] {
+					t.Errorf("push %d: expected error %v, got %v", i, tt.errors[i], err)
+				}
+			}
+
+			queued := queuedHosts(q)
+			if len(queued) != len(tt.queued) {
+				t.Fatalf("expected queue %v, got %v", tt.queued, queued)
+			}
+
+			for i := range queued {
+				if queued[i] != tt.queued[i] {
+					t.Fatalf("expected queue %v, got %v", tt.queued, queued)
+				}
+			}
+
+			if q.dropped != tt.dropped {
+				t.Errorf("expected %d dropped signals, got %d", tt.dropped, q.dropped)
+			}
+		})
+	}
+}
+
+func TestSignalQueueRequeue(t *testing.T) {
+	q := newSignalQueue(0, OverflowReject)
+	q.journal = newJournal("")
+
+	if err := q.Push(testSignals("a")...); err != nil {
+		t.Fatal(err)
+	}
+
+	first := q.Pop()
+	if err := q.Requeue(time.Now(), first); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(q.journal.snapshot); n != 1 {
+		t.Fatalf("expected the requeued signal once in the journal, got %d entries", n)
+	}
+
+	// another worker picks up the signal before the first one calls Done
+	second := q.Pop()
+	if second.id != first.id {
+		t.Fatalf("expected the requeued signal to keep its ID %d, got %d", first.id, second.id)
+	}
+
+	q.Done(first)
+
+	if _, ok := q.inFlight[second.id]; !ok {
+		t.Errorf("expected the signal to stay in flight until the second worker is done")
+	}
+
+	q.Done(second)
+
+	if len(q.inFlight) != 0 || len(q.journal.snapshot) != 0 {
+		t.Errorf("expected an empty queue, got %d in flight and %d journal entries", len(q.inFlight), len(q.journal.snapshot))
+	}
+}
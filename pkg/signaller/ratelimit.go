@@ -0,0 +1,211 @@
+package signaller
+
+import (
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimitsPath exposes the number of throttled signal requests
+const RateLimitsPath = "/api/v1/ratelimits"
+
+// clientIdleTimeout is the duration after which the token bucket (and the
+// throttling statistics) of an idle client are discarded
+const clientIdleTimeout = 10 * time.Minute
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// RateLimiter throttles signal requests using token buckets, both for all
+// requests and for the requests of every single client
+type RateLimiter struct {
+	global *rate.Limiter
+
+	clientRate  rate.Limit
+	clientBurst int
+	clients     map[string]*clientLimiter
+	lastPruned  time.Time
+
+	throttledGlobal  uint64
+	throttledClients map[string]uint64
+	mutex            sync.Mutex
+}
+
+// RateLimitStats contains the number of requests that have been throttled
+type RateLimitStats struct {
+	Global  uint64            `json:"global"`
+	Clients map[string]uint64 `json:"clients"`
+}
+
+// NewRateLimiter creates a rate limiter; rates are given in requests per
+// second, and a rate of zero disables the respective limit
+func NewRateLimiter(globalRate float64, globalBurst int, clientRate float64, clientBurst int) *RateLimiter {
+	l := RateLimiter{
+		clientRate:       rate.Limit(clientRate),
+		clientBurst:      clientBurst,
+		clients:          make(map[string]*clientLimiter),
+		throttledClients: make(map[string]uint64),
+	}
+
+	if globalRate > 0 {
+		l.global = rate.NewLimiter(rate.Limit(globalRate), globalBurst)
+	}
+
+	return &l
+}
+
+// Allow checks if a request of the given client may be processed. If not,
+// the duration after which the client should try again is returned.
+func (l *RateLimiter) Allow(client string) (bool, time.Duration) {
+	now := time.Now()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var clientReservation *rate.Reservation
+
+	if l.clientRate > 0 {
+		c := l.client(client, now)
+		clientReservation = c.limiter.ReserveN(now, 1)
+
+		if delay, ok := reservationDelay(clientReservation, now); !ok {
+			l.throttledClients[client]++
+			return false, delay
+		}
+	}
+
+	if l.global != nil {
+		r := l.global.ReserveN(now, 1)
+
+		if delay, ok := reservationDelay(r, now); !ok {
+			// the client should not be charged for requests that were
+			// throttled by the global limit
+			if clientReservation != nil {
+				clientReservation.CancelAt(now)
+			}
+
+			l.throttledGlobal++
+			return false, delay
+		}
+	}
+
+	return true, 0
+}
+
+// reservationDelay checks if a reservation can be used immediately. If not,
+// the reservation is cancelled and the time until it would have become
+// usable is returned.
+func reservationDelay(r *rate.Reservation, now time.Time) (time.Duration, bool) {
+	if !r.OK() {
+		return time.Duration(math.MaxInt64), false
+	}
+
+	delay := r.DelayFrom(now)
+	if delay == 0 {
+		return 0, true
+	}
+
+	r.CancelAt(now)
+	return delay, false
+}
+
+// client returns the token bucket of a client, creating it if necessary.
+// The caller needs to hold the limiter's mutex.
+func (l *RateLimiter) client(client string, now time.Time) *clientLimiter {
+	if now.Sub(l.lastPruned) > clientIdleTimeout {
+		for k, c := range l.clients {
+			if now.Sub(c.lastSeen) > clientIdleTimeout {
+				delete(l.clients, k)
+				delete(l.throttledClients, k)
+			}
+		}
+
+		l.lastPruned = now
+	}
+
+	c, ok := l.clients[client]
+	if !ok {
+		c = &clientLimiter{limiter: rate.NewLimiter(l.clientRate, l.clientBurst)}
+		l.clients[client] = c
+	}
+
+	c.lastSeen = now
+	return c
+}
+
+// Stats returns the number of throttled requests
+func (l *RateLimiter) Stats() RateLimitStats {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	stats := RateLimitStats{
+		Global:  l.throttledGlobal,
+		Clients: make(map[string]uint64, len(l.throttledClients)),
+	}
+
+	for k, v := range l.throttledClients {
+		stats.Clients[k] = v
+	}
+
+	return stats
+}
+
+// clientIdentity identifies the client of a request for rate limiting by
+// its remote IP address; requests are throttled before authentication, so
+// the caller is not known yet
+func clientIdentity(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// throttle checks the rate limits for a request, and responds with 429 if
+// it must not be processed
+func (b *Signaller) throttle(w http.ResponseWriter, r *http.Request) bool {
+	if b.RateLimiter == nil {
+		return false
+	}
+
+	client := clientIdentity(r)
+
+	ok, retryAfter := b.RateLimiter.Allow(client)
+	if ok {
+		return false
+	}
+
+	logger.Debug("throttling signal request", "method", r.Method, "path", r.URL.Path, "client", client)
+
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if retryAfter > time.Hour {
+		seconds = int(time.Hour.Seconds())
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, "too many requests", http.StatusTooManyRequests)
+
+	return true
+}
+
+func (b *Signaller) serveRateLimits(w http.ResponseWriter) {
+	stats := RateLimitStats{Clients: map[string]uint64{}}
+	if b.RateLimiter != nil {
+		stats = b.RateLimiter.Stats()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		logger.Warning("error while writing rate limit stats", "error", err)
+	}
+}
@@ -0,0 +1,90 @@
+package signaller
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestRateLimiterClients(t *testing.T) {
+	l := NewRateLimiter(0, 0, 1, 2)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("expected request %d within the burst to be allowed", i+1)
+		}
+	}
+
+	ok, retryAfter := l.Allow("a")
+	if ok || retryAfter <= 0 || retryAfter > time.Second {
+		t.Fatalf("expected the request to be throttled for up to a second, got %v after %s", ok, retryAfter)
+	}
+
+	if ok, _ := l.Allow("b"); !ok {
+		t.Fatalf("expected other clients not to be throttled")
+	}
+
+	if stats := l.Stats(); stats.Global != 0 || stats.Clients["a"] != 1 || len(stats.Clients) != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestRateLimiterGlobal(t *testing.T) {
+	l := NewRateLimiter(1, 1, 1, 1)
+
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatalf("expected the first request to be allowed")
+	}
+
+	if ok, _ := l.Allow("b"); ok {
+		t.Fatalf("expected the second request to exceed the global limit")
+	}
+
+	// refill the global bucket; b must not have been charged for the
+	// globally throttled request
+	l.global = rate.NewLimiter(1, 1)
+
+	if ok, _ := l.Allow("b"); !ok {
+		t.Fatalf("expected b not to be charged for a globally throttled request")
+	}
+
+	if stats := l.Stats(); stats.Global != 1 || len(stats.Clients) != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestRateLimiterPrunesIdleClients(t *testing.T) {
+	l := NewRateLimiter(0, 0, 1, 1)
+
+	l.Allow("a")
+	l.Allow("a")
+
+	if stats := l.Stats(); stats.Clients["a"] != 1 {
+		t.Fatalf("expected a to be throttled once, got %+v", stats)
+	}
+
+	l.clients["a"].lastSeen = time.Now().Add(-2 * clientIdleTimeout)
+	l.lastPruned = time.Now().Add(-2 * clientIdleTimeout)
+
+	l.Allow("b")
+
+	if _, ok := l.clients["a"]; ok {
+		t.Errorf("expected the token bucket of the idle client to be discarded")
+	}
+
+	if _, ok := l.Stats().Clients["a"]; ok {
+		t.Errorf("expected the statistics of the idle client to be discarded")
+	}
+}
+
+func TestClientIdentity(t *testing.T) {
+	r := httptest.NewRequest("PURGE", "/", nil)
+	r.RemoteAddr = "10.0.0.1:54321"
+	r.Header.Set("Authorization", "Bearer token")
+
+	if client := clientIdentity(r); client != "10.0.0.1" {
+		t.Errorf("expected the client to be identified by its IP address, got %q", client)
+	}
+}
@@ -0,0 +1,63 @@
+package signaller
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	jitter      = rand.New(rand.NewSource(time.Now().UnixNano()))
+	jitterMutex sync.Mutex
+)
+
+// permanentError marks a delivery error that will not go away by retrying,
+// like a 405 response from an instance that does not support the method
+type permanentError struct {
+	error
+}
+
+func permanent(err error) error {
+	return permanentError{err}
+}
+
+func isPermanent(err error) bool {
+	_, ok := err.(permanentError)
+	return ok
+}
+
+// isRetryableStatus checks if a signal that received a response with the
+// given status code should be delivered again
+func isRetryableStatus(code int) bool {
+	switch code {
+	case 408, 425, 429:
+		return true
+	case 501, 505:
+		return false
+	}
+
+	return code >= 500
+}
+
+// backoff calculates the delay before the given attempt. The delay grows
+// exponentially from RetryBackoff up to MaxRetryBackoff, and is randomized
+// by up to half of its value to avoid retrying in lockstep.
+func (b *Signaller) backoff(attempt int) time.Duration {
+	delay := b.RetryBackoff
+	for i := 1; i < attempt && (b.MaxRetryBackoff <= 0 || delay < b.MaxRetryBackoff); i++ {
+		delay *= 2
+	}
+
+	if b.MaxRetryBackoff > 0 && delay > b.MaxRetryBackoff {
+		delay = b.MaxRetryBackoff
+	}
+
+	if delay <= 0 {
+		return 0
+	}
+
+	jitterMutex.Lock()
+	defer jitterMutex.Unlock()
+
+	return delay/2 + time.Duration(jitter.Int63n(int64(delay/2)+1))
+}
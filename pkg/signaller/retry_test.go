@@ -0,0 +1,138 @@
+package signaller
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff time.Duration
+		max     time.Duration
+		attempt int
+		delay   time.Duration
+	}{
+		{name: "first attempt", backoff: time.Second, max: time.Minute, attempt: 1, delay: time.Second},
+		{name: "doubles per attempt", backoff: time.Second, max: time.Minute, attempt: 4, delay: 8 * time.Second},
+		{name: "capped", backoff: time.Second, max: 10 * time.Second, attempt: 10, delay: 10 * time.Second},
+		{name: "uncapped", backoff: time.Second, max: 0, attempt: 6, delay: 32 * time.Second},
+		{name: "no backoff", backoff: 0, max: time.Minute, attempt: 3, delay: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := Signaller{RetryBackoff: tt.backoff, MaxRetryBackoff: tt.max}
+
+			for i := 0; i < 100; i++ {
+				delay := b.backoff(tt.attempt)
+				if delay < tt.delay/2 || delay > tt.delay {
+					t.Fatalf("expected a delay between %s and %s, got %s", tt.delay/2, tt.delay, delay)
+				}
+			}
+		})
+	}
+}
+
+func TestIsRetryableStatus(t *testing.T) {
+	tests := map[int]bool{
+		400: false,
+		404: false,
+		405: false,
+		408: true,
+		425: true,
+		429: true,
+		500: true,
+		501: false,
+		502: true,
+		503: true,
+		505: false,
+	}
+
+	for code, retryable := range tests {
+		if isRetryableStatus(code) != retryable {
+			t.Errorf("expected isRetryableStatus(%d) to be %v", code, retryable)
+		}
+	}
+}
+
+func TestPermanentError(t *testing.T) {
+	err := errors.New("method not allowed")
+
+	if isPermanent(err) {
+		t.Errorf("expected a plain error not to be permanent")
+	}
+
+	if p := permanent(err); !isPermanent(p) || p.Error() != err.Error() {
+		t.Errorf("expected a permanent error with message %q, got %v", err, p)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	const cooldown = 50 * time.Millisecond
+	c := newCircuitBreaker(2, cooldown)
+
+	if ok, _ := c.Allow("a"); !ok {
+		t.Fatalf("expected a closed circuit for an unknown endpoint")
+	}
+
+	if c.Failure("a") {
+		t.Fatalf("expected the first failure not to open the circuit")
+	}
+
+	if ok, _ := c.Allow("a"); !ok {
+		t.Fatalf("expected the circuit to stay closed below the threshold")
+	}
+
+	if !c.Failure("a") {
+		t.Fatalf("expected the second failure to open the circuit")
+	}
+
+	ok, until := c.Allow("a")
+	if ok || until.Before(time.Now()) {
+		t.Fatalf("expected an open circuit until after now, got %v until %s", ok, until)
+	}
+
+	if ok, _ := c.Allow("b"); !ok {
+		t.Fatalf("expected other endpoints not to be affected")
+	}
+
+	time.Sleep(cooldown + 10*time.Millisecond)
+
+	if ok, _ := c.Allow("a"); !ok {
+		t.Fatalf("expected a half-open circuit to let one probe through")
+	}
+
+	if ok, _ := c.Allow("a"); ok {
+		t.Fatalf("expected a half-open circuit to hold back further signals")
+	}
+
+	if c.Failure("a") {
+		t.Fatalf("expected a failed probe not to report the circuit as newly opened")
+	}
+
+	if ok, _ := c.Allow("a"); ok {
+		t.Fatalf("expected the circuit to be open again after a failed probe")
+	}
+
+	c.Success("a")
+
+	if ok, _ := c.Allow("a"); !ok {
+		t.Fatalf("expected a success to close the circuit")
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	c := newCircuitBreaker(0, time.Minute)
+
+	for i := 0; i < 10; i++ {
+		if c.Failure("a") {
+			t.Fatalf("expected a disabled breaker never to open")
+		}
+	}
+
+	if ok, _ := c.Allow("a"); !ok {
+		t.Fatalf("expected a disabled breaker to allow all signals")
+	}
+}
@@ -0,0 +1,212 @@
+package signaller
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mittwald/kube-httpcache/pkg/watcher"
+)
+
+const (
+	// RoutingAll broadcasts every signal to all frontends
+	RoutingAll = "all"
+
+	// RoutingHash sends signals for a URL only to the frontend that a
+	// "directors.hash()" director (with equal weights, and the frontends
+	// added in the order of the VCL template's .Frontends) picks for it
+	RoutingHash = "hash"
+
+	// RoutingShard sends signals for a URL only to the frontend(s) that a
+	// "directors.shard()" director (with the frontends added by their name,
+	// and the default number of replicas) picks for it when sharding by URL
+	RoutingShard = "shard"
+)
+
+// TargetHeader restricts a signal request to the frontends with the given
+// (comma-separated) pod names
+const TargetHeader = "X-Signaller-Target"
+
+// shardReplicas is the default number of points per backend on the hash
+// ring of the shard director
+const shardReplicas = 67
+
+// varnishHash32 mirrors VRT_HashStrands32, which is used by the hash and
+// shard directors of Varnish: the last four bytes of the SHA256 digest,
+// decoded as little endian
+func varnishHash32(s ...string) uint32 {
+	h := sha256.New()
+	for i := range s {
+		h.Write([]byte(s[i]))
+	}
+
+	digest := h.Sum(nil)
+	return binary.LittleEndian.Uint32(digest[len(digest)-4:])
+}
+
+// hashOwner returns the index of the endpoint that a hash director with
+// equally weighted (and healthy) backends picks for the given key
+func hashOwner(endpoints watcher.EndpointList, key string) int {
+	r := float64(varnishHash32(key)) / 4294967296.0
+	return int(r * float64(len(endpoints)))
+}
+
+type shardPoint struct {
+	point uint32
+	index int
+}
+
+// shardOwners returns the indices of the n endpoints that a shard director
+// picks for the given key (the owner, followed by the alternative backends
+// along the hash ring)
+func shardOwners(endpoints watcher.EndpointList, key string, n int) []int {
+	circle := make([]shardPoint, 0, len(endpoints)*shardReplicas)
+	for i := range endpoints {
+		for j := 0; j < shardReplicas; j++ {
+			circle = append(circle, shardPoint{
+				point: varnishHash32(endpoints[i].Name, strconv.Itoa(j)),
+				index: i,
+			})
+		}
+	}
+
+	sort.Slice(circle, func(a, b int) bool {
+		if circle[a].point != circle[b].point {
+			return circle[a].point < circle[b].point
+		}
+
+		return endpoints[circle[a].index].Name < endpoints[circle[b].index].Name
+	})
+
+	// like the shard director, keys beyond the last point are mapped to the
+	// last point instead of wrapping around
+	k := varnishHash32(key)
+	start := sort.Search(len(circle), func(i int) bool { return circle[i].point >= k })
+	if start == len(circle) {
+		start = len(circle) - 1
+	}
+
+	if n > len(endpoints) {
+		n = len(endpoints)
+	}
+
+	var owners []int
+	seen := make(map[int]bool)
+
+	for i := 0; len(owners) < n; i++ {
+		p := circle[(start+i)%len(circle)]
+		if !seen[p.index] {
+			seen[p.index] = true
+			owners = append(owners, p.index)
+		}
+	}
+
+	return owners
+}
+
+// parseTargets parses the value of the TargetHeader
+func parseTargets(v string) []string {
+	var targets []string
+
+	for _, t := range strings.Split(v, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			targets = append(targets, t)
+		}
+	}
+
+	return targets
+}
+
+// checkTargets checks that all targets are known frontends
+func (b *Signaller) checkTargets(targets []string) error {
+	if len(targets) == 0 {
+		return nil
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for _, target := range targets {
+		found := false
+
+		for i := range b.endpoints.Endpoints {
+			if b.endpoints.Endpoints[i].Name == target {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("unknown target frontend '%s'", target)
+		}
+	}
+
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for i := range list {
+		if list[i] == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// selectEndpoints returns the endpoints that a signal request needs to be
+// delivered to
+func (b *Signaller) selectEndpoints(t *signalTemplate, endpoints watcher.EndpointList) (watcher.EndpointList, error) {
+	if len(t.Targets) > 0 {
+		var selected watcher.EndpointList
+
+		for _, target := range t.Targets {
+			found := false
+
+			for i := range endpoints {
+				if endpoints[i].Name == target {
+					selected = append(selected, endpoints[i])
+					found = true
+				}
+			}
+
+			if !found {
+				return nil, fmt.Errorf("unknown target frontend '%s'", target)
+			}
+		}
+
+		return selected, nil
+	}
+
+	if t.RouteKey == "" || len(endpoints) == 0 {
+		return endpoints, nil
+	}
+
+	switch b.Routing {
+	case RoutingHash:
+		return watcher.EndpointList{endpoints[hashOwner(endpoints, t.RouteKey)]}, nil
+
+	case RoutingShard:
+		replicas := b.RoutingReplicas
+		if replicas < 1 {
+			replicas = 1
+		}
+
+		var selected watcher.EndpointList
+		for _, i := range shardOwners(endpoints, t.RouteKey, replicas) {
+			selected = append(selected, endpoints[i])
+		}
+
+		return selected, nil
+	}
+
+	return endpoints, nil
+}
+
+// routed checks if a signal request is delivered to a subset of frontends
+func (b *Signaller) routed(t *signalTemplate) bool {
+	return len(t.Targets) > 0 || (t.RouteKey != "" && b.Routing != "" && b.Routing != RoutingAll)
+}
@@ -0,0 +1,163 @@
+package signaller
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mittwald/kube-httpcache/pkg/watcher"
+)
+
+// The expected values below follow VRT_HashStrands32 and the ring
+// construction of the shard director (67 points per backend, hashed from
+// the backend name and the replica number)
+
+func testEndpoints(names ...string) watcher.EndpointList {
+	endpoints := make(watcher.EndpointList, len(names))
+	for i := range names {
+		endpoints[i] = watcher.Endpoint{Name: names[i], Host: "10.0.0." + names[i][len(names[i])-1:], Port: "80"}
+	}
+
+	return endpoints
+}
+
+func TestVarnishHash32(t *testing.T) {
+	tests := []struct {
+		key  string
+		hash uint32
+	}{
+		{"/", 4053860029},
+		{"/products/42", 2800107423},
+		{"/a", 674911274},
+		{"/b", 3406963862},
+		{"/c", 3654614731},
+	}
+
+	for _, test := range tests {
+		if h := varnishHash32(test.key); h != test.hash {
+			t.Errorf("expected hash of %q to be %d, got %d", test.key, test.hash, h)
+		}
+	}
+
+	if varnishHash32("/prod", "ucts/42") != varnishHash32("/products/42") {
+		t.Errorf("expected strands to be hashed as their concatenation")
+	}
+}
+
+func TestHashOwner(t *testing.T) {
+	tests := []struct {
+		key       string
+		endpoints int
+		owner     int
+	}{
+		{"/", 3, 2},
+		{"/products/42", 3, 1},
+		{"/a", 3, 0},
+		{"/b", 3, 2},
+		{"/", 5, 4},
+		{"/products/42", 5, 3},
+		{"/d", 5, 1},
+		{"/", 1, 0},
+	}
+
+	names := []string{"pod-0", "pod-1", "pod-2", "pod-3", "pod-4"}
+
+	for _, test := range tests {
+		if owner := hashOwner(testEndpoints(names[:test.endpoints]...), test.key); owner != test.owner {
+			t.Errorf("expected owner of %q among %d endpoints to be %d, got %d", test.key, test.endpoints, test.owner, owner)
+		}
+	}
+}
+
+func TestShardOwners(t *testing.T) {
+	tests := []struct {
+		key      string
+		names    []string
+		replicas int
+		owners   []int
+	}{
+		{"/", []string{"pod-0", "pod-1", "pod-2"}, 1, []int{1}},
+		{"/", []string{"pod-0", "pod-1", "pod-2"}, 2, []int{1, 0}},
+		{"/products/42", []string{"pod-0", "pod-1", "pod-2"}, 2, []int{2, 1}},
+		{"/a", []string{"pod-0", "pod-1", "pod-2"}, 2, []int{0, 1}},
+		{"/b", []string{"pod-0", "pod-1", "pod-2"}, 1, []int{2}},
+		{"/b", []string{"pod-0", "pod-1", "pod-2", "pod-3"}, 1, []int{3}},
+		{"/d", []string{"pod-0", "pod-1", "pod-2", "pod-3"}, 1, []int{3}},
+		{"/a", []string{"pod-0", "pod-1"}, 5, []int{0, 1}},
+	}
+
+	for _, test := range tests {
+		owners := shardOwners(testEndpoints(test.names...), test.key, test.replicas)
+		if !reflect.DeepEqual(owners, test.owners) {
+			t.Errorf("expected owners of %q among %v to be %v, got %v", test.key, test.names, test.owners, owners)
+		}
+	}
+}
+
+func TestShardOwnersIgnoresEndpointOrder(t *testing.T) {
+	endpoints := testEndpoints("pod-0", "pod-1", "pod-2")
+	reversed := watcher.EndpointList{endpoints[2], endpoints[1], endpoints[0]}
+
+	for _, key := range []string{"/", "/a", "/b", "/c", "/products/42"} {
+		owner := endpoints[shardOwners(endpoints, key, 1)[0]].Name
+		if other := reversed[shardOwners(reversed, key, 1)[0]].Name; owner != other {
+			t.Errorf("expected owner of %q not to depend on the endpoint order, got %s and %s", key, owner, other)
+		}
+	}
+}
+
+func TestParseTargets(t *testing.T) {
+	tests := []struct {
+		value   string
+		targets []string
+	}{
+		{"", nil},
+		{"pod-0", []string{"pod-0"}},
+		{" pod-0 , pod-1,,", []string{"pod-0", "pod-1"}},
+	}
+
+	for _, test := range tests {
+		if targets := parseTargets(test.value); !reflect.DeepEqual(targets, test.targets) {
+			t.Errorf("expected %q to be parsed as %v, got %v", test.value, test.targets, targets)
+		}
+	}
+}
+
+func TestSelectEndpoints(t *testing.T) {
+	endpoints := testEndpoints("pod-0", "pod-1", "pod-2")
+
+	tests := []struct {
+		name     string
+		routing  string
+		replicas int
+		template signalTemplate
+		selected []string
+		err      bool
+	}{
+		{"all", RoutingAll, 0, signalTemplate{RouteKey: "/"}, []string{"pod-0", "pod-1", "pod-2"}, false},
+		{"hash", RoutingHash, 0, signalTemplate{RouteKey: "/"}, []string{"pod-2"}, false},
+		{"shard", RoutingShard, 0, signalTemplate{RouteKey: "/"}, []string{"pod-1"}, false},
+		{"shard replicas", RoutingShard, 2, signalTemplate{RouteKey: "/"}, []string{"pod-1", "pod-0"}, false},
+		{"no route key", RoutingHash, 0, signalTemplate{}, []string{"pod-0", "pod-1", "pod-2"}, false},
+		{"targets", RoutingHash, 0, signalTemplate{RouteKey: "/", Targets: []string{"pod-0"}}, []string{"pod-0"}, false},
+		{"unknown target", RoutingAll, 0, signalTemplate{Targets: []string{"pod-9"}}, nil, true},
+	}
+
+	for _, test := range tests {
+		b := Signaller{Routing: test.routing, RoutingReplicas: test.replicas}
+
+		selected, err := b.selectEndpoints(&test.template, endpoints)
+		if (err != nil) != test.err {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+
+		var names []string
+		for i := range selected {
+			names = append(names, selected[i].Name)
+		}
+
+		if !reflect.DeepEqual(names, test.selected) {
+			t.Errorf("%s: expected %v to be selected, got %v", test.name, test.selected, names)
+		}
+	}
+}
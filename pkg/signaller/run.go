@@ -1,31 +1,165 @@
 package signaller
 
 import (
-	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/mittwald/kube-httpcache/pkg/watcher"
 )
 
+const (
+	// adminTimeout limits the duration of a single ban issued via the admin port
+	adminTimeout = 10 * time.Second
+
+	// signalTimeout limits the duration of a single HTTP signal delivery
+	signalTimeout = 10 * time.Second
+
+	// queueFullRetryAfter is suggested to clients whose requests were
+	// rejected because the signal queue was full
+	queueFullRetryAfter = 5 * time.Second
+)
+
 func (b *Signaller) Run() error {
 	server := &http.Server{
 		Addr:    b.Address + ":" + strconv.Itoa(b.Port),
 		Handler: b, // Not sure How this Handler is working
 	}
 
+	if b.EndpointScheme == "https" {
+		transport, err := b.endpointTransport()
+		if err != nil {
+			return err
+		}
+
+		b.client = &http.Client{
+			Timeout:   signalTimeout,
+			Transport: transport,
+		}
+	}
+
+	if b.TLSCertFile != "" {
+		// followers forward signal requests to the leader's signaller
+		transport, err := b.endpointTransport()
+		if err != nil {
+			return err
+		}
+
+		b.forwardTransport = transport
+	}
+
+	if b.JournalFile != "" {
+		j := newJournal(b.JournalFile)
+
+		items, err := j.load()
+		if err != nil {
+			return err
+		}
+
+		if len(items) > 0 {
+			logger.Info("restoring signals from journal", "signals", len(items), "file", b.JournalFile)
+		}
+
+		for i := range items {
+			if err := b.queue.push(items[i].notBefore, true, items[i].signal); err != nil {
+				logger.Warning("could not restore signal", "endpoint", endpointKey(items[i].signal.Endpoint), "error", err)
+			}
+		}
+
+		b.queue.mutex.Lock()
+		b.queue.journal = j
+		b.queue.persist()
+		b.queue.mutex.Unlock()
+
+		go j.run()
+	}
+
+	if b.batcher != nil {
+		go b.runBatcher()
+	}
+
 	for i := 0; i < b.WorkersCount; i++ {
 		go b.ProcessSignalQueue() // goroutine making a request outta signal channel
 	}
 
-	return server.ListenAndServe() // listen from server
+	if b.TLSCertFile == "" {
+		return server.ListenAndServe() // listen from server
+	}
+
+	tlsConfig, err := b.serverTLSConfig()
+	if err != nil {
+		return err
+	}
+
+	server.TLSConfig = tlsConfig
+
+	return server.ListenAndServeTLS("", "")
 }
 
 func (b *Signaller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	credentials := r.Header.Get("Authorization")
+
+	// the forwarded header is dropped unless the request comes from the
+	// signaller it names, so that clients cannot use it to keep a follower
+	// from forwarding their requests
+	forwarded := b.forwardedByPeer(r)
+	if !forwarded {
+		r.Header.Del(ForwardedHeader)
+	}
+
+	// requests are throttled before authentication, so that floods of
+	// unauthenticated requests do not reach the TokenReview API; forwarded
+	// requests have already been throttled by the follower
+	if !forwarded && b.throttle(w, r) {
+		return
+	}
+
+	caller, ok := b.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method == http.MethodGet && r.URL.Path == LeaderPath {
+		b.serveLeader(w)
+		return
+	}
+
+	if r.Method == http.MethodGet && r.URL.Path == RateLimitsPath {
+		b.serveRateLimits(w)
+		return
+	}
+
+	// forwarded requests are processed, since the peer considers this
+	// signaller to be the leader
+	if !forwarded && b.rejectWhileElectionPending(w) {
+		return
+	}
+
+	if r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, BroadcastsPath) {
+		if !b.forwardToLeader(w, r, credentials) {
+			b.serveBroadcast(w, r)
+		}
+
+		return
+	}
+
+	if b.forwardToLeader(w, r, credentials) {
+		return
+	}
+
+	r.Header.Del(ForwardedHeader)
+
+	if r.Method == http.MethodPost && r.URL.Path == InvalidatePath {
+		b.serveInvalidate(w, r, caller)
+		return
+	}
+
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		b.errors <- err
@@ -33,61 +167,287 @@ func (b *Signaller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	glog.V(5).Infof("received a signal request: %+v", r)
+	logger.Debug("received a signal request", "method", r.Method, "uri", r.RequestURI, "host", r.Host, "remote", r.RemoteAddr)
+
+	var wait time.Duration
+	if v := r.Header.Get(WaitHeader); v != "" {
+		wait, err = time.ParseDuration(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid %s header: %s", WaitHeader, err.Error()), http.StatusBadRequest)
+			return
+		}
+	}
+
+	async := r.Header.Get(AsyncHeader) != ""
+	targets := parseTargets(r.Header.Get(TargetHeader))
+
+	if err := b.checkTargets(targets); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.Header.Del(WaitHeader)
+	r.Header.Del(AsyncHeader)
+	r.Header.Del(TargetHeader)
+
+	t := &signalTemplate{
+		Method:     r.Method,
+		RequestURI: r.RequestURI,
+		Host:       r.Host,
+		Header:     r.Header.Clone(),
+		Body:       body,
+		Received:   time.Now(),
+		Targets:    targets,
+	}
+	t.Header.Set("X-Forwarded-For", r.RemoteAddr)
+
+	if r.Method == "PURGE" {
+		t.RouteKey = r.RequestURI
+	}
 
+	if b.Mode == ModeAdmin {
+		t.Ban, err = banExpressionFromRequest(r, body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	broadcast, err := b.submit(t, wait > 0 || async)
+	if err == ErrQueueFull {
+		logger.Warning("rejecting signal request", "method", r.Method, "uri", r.RequestURI, "error", err)
+		w.Header().Set("Retry-After", strconv.Itoa(int(queueFullRetryAfter.Seconds())))
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		b.errors <- err
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	b.respond(w, broadcast, wait, async)
+}
+
+// submit accepts a signal request for broadcasting. Duplicates of recent
+// requests are skipped (returning the broadcast of the original request, if
+// tracked), and bans are batched if enabled.
+func (b *Signaller) submit(t *signalTemplate, track bool) (*Broadcast, error) {
+	key := t.key()
+
+	reservation, broadcast, duplicate := b.dedup.Reserve(key, track)
+	if duplicate {
+		logger.Debug("ignoring duplicate signal request", "method", t.Method, "uri", t.RequestURI)
+		return broadcast, nil
+	}
+
+	if b.batcher != nil && t.Ban != "" && !track && !b.routed(t) {
+		b.batcher.Add(t.Ban)
+		b.dedup.Complete(reservation, nil)
+		return nil, nil
+	}
+
+	broadcast, err := b.enqueue(t, track)
+	if err != nil {
+		b.dedup.Release(key, reservation)
+		return nil, err
+	}
+
+	b.dedup.Complete(reservation, broadcast)
+	return broadcast, nil
+}
+
+// enqueue queues a signal request for delivery to all current endpoints. If
+// track is set, the results are collected in a broadcast.
+func (b *Signaller) enqueue(t *signalTemplate, track bool) (*Broadcast, error) {
 	b.mutex.RLock()
 	endpoints := make([]watcher.Endpoint, len(b.endpoints.Endpoints))
 	copy(endpoints, b.endpoints.Endpoints) // why is it copying endpoints?
 	b.mutex.RUnlock()
 
-	for _, endpoint := range endpoints {
-		url := fmt.Sprintf("%s://%s:%s%s", b.EndpointScheme, endpoint.Host, endpoint.Port, r.RequestURI)
-		request, err := http.NewRequest(r.Method, url, bytes.NewReader(body)) // Method eg (GET), URL of the endpoint. With those variables make a new http request
+	endpoints, err := b.selectEndpoints(t, endpoints)
+	if err != nil {
+		return nil, err
+	}
+
+	var broadcast *Broadcast
+	if track {
+		broadcast, err = newBroadcast(endpoints)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	signals := make([]Signal, 0, len(endpoints))
+	for i, endpoint := range endpoints {
+		signal, err := b.buildSignal(t, endpoint)
 		if err != nil {
-			b.errors <- err
+			return nil, err
 		}
-		//fill up http request as such and puth it in a channel
-		request.Header = r.Header.Clone()
-		request.Host = r.Host
-		request.Header.Set("X-Forwarded-For", r.RemoteAddr)
-		b.signalQueue <- Signal{request, 0}
+
+		signal.Broadcast = broadcast
+		signal.Index = i
+		signals = append(signals, signal)
+	}
+
+	if err := b.queue.Push(signals...); err != nil {
+		return nil, err
+	}
+
+	b.history.Add(t)
+
+	if broadcast != nil {
+		b.trackBroadcast(broadcast)
 	}
 
-	fmt.Fprintf(w, "Signal request is being broadcasted.")
+	return broadcast, nil
+}
+
+func (b *Signaller) respond(w http.ResponseWriter, broadcast *Broadcast, wait time.Duration, async bool) {
+	switch {
+	case async && broadcast != nil:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(map[string]string{"id": broadcast.ID, "location": BroadcastsPath + broadcast.ID})
+	case broadcast != nil:
+		if !broadcast.Wait(wait) {
+			broadcast.writeJSON(w, http.StatusGatewayTimeout)
+		} else if !broadcast.Succeeded() {
+			broadcast.writeJSON(w, http.StatusBadGateway)
+		} else {
+			broadcast.writeJSON(w, http.StatusOK)
+		}
+	default:
+		fmt.Fprintf(w, "Signal request is being broadcasted.")
+	}
 }
 
 func (b *Signaller) ProcessSignalQueue() {
-	client := &http.Client{}
+	for {
+		signal := b.queue.Pop()
 
-	for signal := range b.signalQueue {
-		response, err := client.Do(signal.Request) // Make a request and get a response
+		endpoint := endpointKey(signal.Endpoint)
+		if ok, until := b.breaker.Allow(endpoint); !ok {
+			// hold back the signal without counting an attempt, so that a
+			// dead endpoint does not keep the workers busy
+			b.reschedule(signal, until)
+			b.queue.Done(signal)
+			continue
+		}
+
+		if signal.Ban != "" {
+			b.processBan(signal)
+			b.queue.Done(signal)
+			continue
+		}
+
+		// the request body has been consumed by previous attempts
+		if signal.Request.GetBody != nil {
+			body, err := signal.Request.GetBody()
+			if err == nil {
+				signal.Request.Body = body
+			}
+		}
+
+		response, err := b.client.Do(signal.Request) // Make a request and get a response
 		if err != nil {
-			glog.Errorf("singal broadcast error: %v", err.Error())
-			glog.Infof("retring in %v", b.RetryBackoff)
-			b.Retry(signal)
+			logger.Error("signal broadcast error", "endpoint", endpointKey(signal.Endpoint), "attempt", signal.Attempt+1, "error", err)
+			b.complete(signal, 0, err)
 		} else if response.StatusCode >= 400 && response.StatusCode <= 599 {
-			glog.Warningf("singal broadcast error: unusual status code from %s: %v", response.Request.URL.Host, response.Status)
-			glog.Infof("retring in %v", b.RetryBackoff)
-			b.Retry(signal)
+			logger.Warning("signal broadcast error: unusual status code", "endpoint", endpointKey(signal.Endpoint), "attempt", signal.Attempt+1, "status", response.StatusCode)
+
+			err := fmt.Errorf("unusual status code: %s", response.Status)
+			if !isRetryableStatus(response.StatusCode) {
+				err = permanent(err)
+			}
+
+			b.complete(signal, response.StatusCode, err)
 		} else {
-			glog.V(5).Infof("recieved a signal response from %s: %+v", response.Request.URL.Host, response)
+			logger.Debug("received a signal response", "endpoint", endpointKey(signal.Endpoint), "attempt", signal.Attempt+1, "status", response.StatusCode)
+			b.complete(signal, response.StatusCode, nil)
 		}
 
 		// after reading all the response, still leftover? -> error
 		if response != nil {
 			if err := response.Body.Close(); err != nil {
-				glog.Error("error on closing response body:", err)
+				logger.Error("error on closing response body", "endpoint", endpointKey(signal.Endpoint), "error", err)
 			}
 		}
+
+		b.queue.Done(signal)
+	}
+}
+
+func (b *Signaller) processBan(signal Signal) {
+	ctx, cancel := context.WithTimeout(context.Background(), adminTimeout)
+	defer cancel()
+
+	if err := b.banViaAdmin(ctx, signal.Endpoint, signal.Ban); err != nil {
+		logger.Error("signal ban error", "endpoint", endpointKey(signal.Endpoint), "attempt", signal.Attempt+1, "error", err)
+		b.complete(signal, 0, err)
+		return
+	}
+
+	b.complete(signal, http.StatusOK, nil)
+}
+
+// complete records the result of a delivery attempt in the circuit breaker
+// and the signal's broadcast (if any), and schedules a retry on failure
+func (b *Signaller) complete(signal Signal, statusCode int, err error) {
+	endpoint := endpointKey(signal.Endpoint)
+
+	switch {
+	case err == nil, isPermanent(err):
+		// the endpoint is alive, even if it did not accept the signal
+		b.breaker.Success(endpoint)
+	case b.breaker.Failure(endpoint):
+		logger.Warning("opening circuit after repeated failures; pausing deliveries", "endpoint", endpoint, "cooldown", b.breaker.cooldown)
+	}
+
+	if signal.Broadcast != nil {
+		final := err == nil || isPermanent(err) || signal.Attempt+1 >= b.MaxRetries
+		signal.Broadcast.record(signal.Index, signal.Attempt+1, statusCode, err, final)
+	}
+
+	if err != nil && !isPermanent(err) {
+		b.Retry(signal)
 	}
 }
 
 func (b *Signaller) Retry(signal Signal) {
 	signal.Attempt++                   // add up the attempt number
 	if signal.Attempt < b.MaxRetries { // as far as the attempt number is smaller than the maxretry number
-		go func() {
-			time.Sleep(b.RetryBackoff) // sleep it for retrybackoff duration
-			b.signalQueue <- signal    // add a new signal into a channel
-		}()
+		delay := b.backoff(signal.Attempt)
+		logger.Info("retrying signal", "endpoint", endpointKey(signal.Endpoint), "attempt", signal.Attempt, "delay", delay)
+
+		b.reschedule(signal, time.Now().Add(delay))
 	}
 }
+
+// reschedule queues a signal again for delivery at the given point in time
+func (b *Signaller) reschedule(signal Signal, notBefore time.Time) {
+	b.mutex.RLock()
+	known := b.endpoints.Endpoints.Contains(&signal.Endpoint)
+	b.mutex.RUnlock()
+
+	if !known {
+		logger.Debug("not rescheduling signal for removed endpoint", "endpoint", endpointKey(signal.Endpoint))
+
+		if signal.Broadcast != nil {
+			signal.Broadcast.record(signal.Index, signal.Attempt, 0, errEndpointRemoved, true)
+		}
+
+		return
+	}
+
+	if err := b.queue.Requeue(notBefore, signal); err != nil {
+		logger.Warning("could not reschedule signal", "endpoint", endpointKey(signal.Endpoint), "attempt", signal.Attempt, "error", err)
+
+		if signal.Broadcast != nil {
+			signal.Broadcast.record(signal.Index, signal.Attempt, 0, err, true)
+		}
+	}
+}
+
+func endpointKey(e watcher.Endpoint) string {
+	return net.JoinHostPort(e.Host, e.Port)
+}
@@ -0,0 +1,95 @@
+package signaller
+
+import (
+	"sort"
+	"time"
+)
+
+// QueueState describes the contents of the signal queue
+type QueueState struct {
+	Capacity int    `json:"capacity"`
+	Overflow string `json:"overflow"`
+	Due      int    `json:"due"`
+	Delayed  int    `json:"delayed"`
+	InFlight int    `json:"inFlight"`
+	Dropped  uint64 `json:"dropped"`
+}
+
+// State describes the current state of a signaller
+type State struct {
+	Mode         string          `json:"mode"`
+	Routing      string          `json:"routing"`
+	Endpoints    []string        `json:"endpoints"`
+	Queue        QueueState      `json:"queue"`
+	OpenCircuits []string        `json:"openCircuits"`
+	Leader       LeaderStatus    `json:"leader"`
+	Throttled    *RateLimitStats `json:"throttled,omitempty"`
+}
+
+// State returns the current state of the signaller
+func (b *Signaller) State() State {
+	b.mutex.RLock()
+	endpoints := make([]string, 0, len(b.endpoints.Endpoints))
+	for i := range b.endpoints.Endpoints {
+		endpoints = append(endpoints, endpointKey(b.endpoints.Endpoints[i]))
+	}
+	b.mutex.RUnlock()
+
+	s := State{
+		Mode:         b.Mode,
+		Routing:      b.Routing,
+		Endpoints:    endpoints,
+		Queue:        b.queue.State(),
+		OpenCircuits: b.breaker.Open(),
+		Leader:       b.LeaderStatus(),
+	}
+
+	if b.RateLimiter != nil {
+		stats := b.RateLimiter.Stats()
+		s.Throttled = &stats
+	}
+
+	return s
+}
+
+// State returns the number of queued signals
+func (q *signalQueue) State() QueueState {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	s := QueueState{
+		Capacity: q.capacity,
+		Overflow: q.overflow,
+		InFlight: len(q.inFlight),
+		Dropped:  q.dropped,
+	}
+
+	now := time.Now()
+	for i := range q.items {
+		if q.items[i].notBefore.After(now) {
+			s.Delayed++
+		} else {
+			s.Due++
+		}
+	}
+
+	return s
+}
+
+// Open returns the endpoints whose circuit is currently open
+func (c *circuitBreaker) Open() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	open := []string{}
+	now := time.Now()
+
+	for endpoint, state := range c.endpoints {
+		if c.threshold > 0 && state.failures >= c.threshold && now.Before(state.openUntil) {
+			open = append(open, endpoint)
+		}
+	}
+
+	sort.Strings(open)
+	return open
+}
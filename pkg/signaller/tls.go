@@ -0,0 +1,315 @@
+package signaller
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// reloadCheckInterval defines how often certificate files are checked for
+// modifications
+const reloadCheckInterval = 10 * time.Second
+
+// keyPairReloader loads a certificate and its private key from disk, and
+// reloads them when either file has been modified
+type keyPairReloader struct {
+	certFile string
+	keyFile  string
+
+	cert        *tls.Certificate
+	modTime     time.Time
+	lastChecked time.Time
+	mutex       sync.Mutex
+}
+
+func newKeyPairReloader(certFile, keyFile string) (*keyPairReloader, error) {
+	r := &keyPairReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if _, err := r.get(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+
+	for _, f := range files {
+		stat, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if stat.ModTime().After(latest) {
+			latest = stat.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+func (r *keyPairReloader) get() (*tls.Certificate, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.cert != nil && time.Since(r.lastChecked) < reloadCheckInterval {
+		return r.cert, nil
+	}
+
+	r.lastChecked = time.Now()
+
+	modTime, err := latestModTime(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			logger.Warning("error while checking certificate for changes", "file", r.certFile, "error", err)
+			return r.cert, nil
+		}
+
+		return nil, err
+	}
+
+	if r.cert != nil && modTime.Equal(r.modTime) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			logger.Warning("error while reloading certificate; keeping previous certificate", "file", r.certFile, "error", err)
+			return r.cert, nil
+		}
+
+		return nil, err
+	}
+
+	if r.cert != nil {
+		logger.Info("reloaded certificate", "file", r.certFile)
+	}
+
+	r.cert = &cert
+	r.modTime = modTime
+
+	return r.cert, nil
+}
+
+func (r *keyPairReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.get()
+}
+
+func (r *keyPairReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.get()
+}
+
+// certPoolReloader loads a CA bundle from disk, and reloads it when the file
+// has been modified
+type certPoolReloader struct {
+	file string
+
+	pool        *x509.CertPool
+	modTime     time.Time
+	lastChecked time.Time
+	mutex       sync.Mutex
+}
+
+func newCertPoolReloader(file string) (*certPoolReloader, error) {
+	r := &certPoolReloader{file: file}
+
+	if _, err := r.get(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(contents) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+
+	return pool, nil
+}
+
+func (r *certPoolReloader) get() (*x509.CertPool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.pool != nil && time.Since(r.lastChecked) < reloadCheckInterval {
+		return r.pool, nil
+	}
+
+	r.lastChecked = time.Now()
+
+	modTime, err := latestModTime(r.file)
+	if err != nil {
+		if r.pool != nil {
+			logger.Warning("error while checking CA bundle for changes", "file", r.file, "error", err)
+			return r.pool, nil
+		}
+
+		return nil, err
+	}
+
+	if r.pool != nil && modTime.Equal(r.modTime) {
+		return r.pool, nil
+	}
+
+	pool, err := loadCertPool(r.file)
+	if err != nil {
+		if r.pool != nil {
+			logger.Warning("error while reloading CA bundle; keeping previous bundle", "file", r.file, "error", err)
+			return r.pool, nil
+		}
+
+		return nil, err
+	}
+
+	if r.pool != nil {
+		logger.Info("reloaded CA bundle", "file", r.file)
+	}
+
+	r.pool = pool
+	r.modTime = modTime
+
+	return r.pool, nil
+}
+
+// serverTLSConfig builds the TLS configuration of the signaller listener.
+// Certificates and client CAs are reloaded when their files change.
+func (b *Signaller) serverTLSConfig() (*tls.Config, error) {
+	keyPair, err := newKeyPairReloader(b.TLSCertFile, b.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var clientCAs *certPoolReloader
+	if b.ClientCAFile != "" {
+		clientCAs, err = newCertPoolReloader(b.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return newServerTLSConfig(keyPair, clientCAs), nil
+}
+
+// newServerTLSConfig builds a server TLS configuration from the given
+// reloaders. Client certificates are only verified if clientCAs is set.
+func newServerTLSConfig(keyPair *keyPairReloader, clientCAs *certPoolReloader) *tls.Config {
+	// the protocols are set explicitly, since http.Server only adds them to
+	// its copy of the config, which GetConfigForClient does not see
+	config := &tls.Config{
+		GetCertificate: keyPair.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+
+	if clientCAs == nil {
+		return config
+	}
+
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		pool, err := clientCAs.get()
+		if err != nil {
+			return nil, err
+		}
+
+		return &tls.Config{
+			GetCertificate: keyPair.GetCertificate,
+			NextProtos:     config.NextProtos,
+			ClientCAs:      pool,
+			ClientAuth:     tls.VerifyClientCertIfGiven,
+		}, nil
+	}
+
+	return config
+}
+
+// endpointTransport builds the transport used for broadcasting signals to
+// the frontends and forwarding them to the leader via HTTPS. The CA bundle
+// is reloaded when it changes, since it is applied on every new connection.
+func (b *Signaller) endpointTransport() (*http.Transport, error) {
+	config, err := b.endpointTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if b.EndpointCAFile == "" {
+		return &http.Transport{TLSClientConfig: config}, nil
+	}
+
+	rootCAs, err := newCertPoolReloader(b.EndpointCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+
+	return &http.Transport{
+		DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			pool, err := rootCAs.get()
+			if err != nil {
+				return nil, err
+			}
+
+			c := config.Clone()
+			c.RootCAs = pool
+
+			if c.ServerName == "" {
+				c.ServerName, _, _ = net.SplitHostPort(addr)
+			}
+
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+
+			if deadline, ok := ctx.Deadline(); ok {
+				_ = conn.SetDeadline(deadline)
+			}
+
+			tlsConn := tls.Client(conn, c)
+			if err := tlsConn.Handshake(); err != nil {
+				conn.Close()
+				return nil, err
+			}
+
+			_ = conn.SetDeadline(time.Time{})
+			return tlsConn, nil
+		},
+	}, nil
+}
+
+// endpointTLSConfig builds the TLS configuration used for broadcasting
+// signals to the frontends via HTTPS, except for the CA bundle
+func (b *Signaller) endpointTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: b.EndpointServerName,
+	}
+
+	if b.EndpointCertFile != "" {
+		keyPair, err := newKeyPairReloader(b.EndpointCertFile, b.EndpointKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.GetClientCertificate = keyPair.GetClientCertificate
+	}
+
+	return config, nil
+}
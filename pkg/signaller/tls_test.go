@@ -0,0 +1,305 @@
+package signaller
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testIssuer signs certificates for the TLS tests; a nil issuer creates
+// self-signed CA certificates
+type testIssuer struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, name string, serial int64) (*testIssuer, []byte) {
+	certPEM, keyPEM := (*testIssuer)(nil).issue(t, name, serial)
+
+	keyPair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testIssuer{cert: cert, key: keyPair.PrivateKey.(*ecdsa.PrivateKey)}, certPEM
+}
+
+func (ca *testIssuer) issue(t *testing.T, name string, serial int64) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	parent, signer := template, key
+	if ca == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		parent, signer = ca.cert, ca.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// replaceFile writes a file and sets its modification time relative to now,
+// so that changes are detected regardless of the file system's resolution
+func replaceFile(t *testing.T, file string, contents []byte, age time.Duration) {
+	if err := ioutil.WriteFile(file, contents, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	modTime := time.Now().Add(age)
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func servedSerial(t *testing.T, r *keyPairReloader) int64 {
+	cert, err := r.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return leaf.SerialNumber.Int64()
+}
+
+func TestKeyPairReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+
+	ca, _ := newTestCA(t, "ca", 1)
+
+	cert, key := ca.issue(t, "server", 10)
+	replaceFile(t, certFile, cert, -time.Hour)
+	replaceFile(t, keyFile, key, -time.Hour)
+
+	r, err := newKeyPairReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, key = ca.issue(t, "server", 11)
+	replaceFile(t, certFile, cert, time.Minute)
+	replaceFile(t, keyFile, key, time.Minute)
+
+	if serial := servedSerial(t, r); serial != 10 {
+		t.Errorf("expected the files not to be checked again within %s, got serial %d", reloadCheckInterval, serial)
+	}
+
+	r.lastChecked = time.Now().Add(-reloadCheckInterval)
+
+	if serial := servedSerial(t, r); serial != 11 {
+		t.Errorf("expected the replaced certificate to be served, got serial %d", serial)
+	}
+
+	// a certificate that does not match its key is rejected
+	cert, _ = ca.issue(t, "server", 12)
+	replaceFile(t, certFile, cert, 2*time.Minute)
+	r.lastChecked = time.Now().Add(-reloadCheckInterval)
+
+	if serial := servedSerial(t, r); serial != 11 {
+		t.Errorf("expected the previous certificate to be kept, got serial %d", serial)
+	}
+
+	if err := os.Remove(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	r.lastChecked = time.Now().Add(-reloadCheckInterval)
+
+	if serial := servedSerial(t, r); serial != 11 {
+		t.Errorf("expected the previous certificate to be kept while the key is missing, got serial %d", serial)
+	}
+}
+
+func TestCertPoolReloader(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ca.crt")
+
+	oldCA, oldPEM := newTestCA(t, "old-ca", 1)
+	newCA, newPEM := newTestCA(t, "new-ca", 2)
+	replaceFile(t, file, oldPEM, -time.Hour)
+
+	r, err := newCertPoolReloader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifies := func(ca *testIssuer) bool {
+		pool, err := r.get()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		certPEM, _ := ca.issue(t, "client", 10)
+		block, _ := pem.Decode(certPEM)
+		leaf, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = leaf.Verify(x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+		return err == nil
+	}
+
+	replaceFile(t, file, newPEM, time.Minute)
+
+	if !verifies(oldCA) || verifies(newCA) {
+		t.Errorf("expected the file not to be checked again within %s", reloadCheckInterval)
+	}
+
+	r.lastChecked = time.Now().Add(-reloadCheckInterval)
+
+	if verifies(oldCA) || !verifies(newCA) {
+		t.Errorf("expected the replaced CA bundle to be used")
+	}
+
+	replaceFile(t, file, []byte("not a certificate"), 2*time.Minute)
+	r.lastChecked = time.Now().Add(-reloadCheckInterval)
+
+	if !verifies(newCA) {
+		t.Errorf("expected the previous CA bundle to be kept")
+	}
+}
+
+// handshake connects to a TLS server with the given configuration, and
+// returns the serial number of the server certificate and the server's
+// handshake error
+func handshake(t *testing.T, serverConfig, clientConfig *tls.Config) (int64, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	errs := make(chan error, 1)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer conn.Close()
+
+		errs <- tls.Server(conn, serverConfig).Handshake()
+	}()
+
+	conn, err := tls.Dial("tcp", l.Addr().String(), clientConfig)
+	if err != nil {
+		<-errs
+		t.Fatal(err)
+	}
+
+	serial := conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	conn.Close()
+
+	return serial, <-errs
+}
+
+func TestServerTLSConfigReloads(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+
+	serverCA, serverCAPEM := newTestCA(t, "server-ca", 1)
+	oldClientCA, oldClientCAPEM := newTestCA(t, "old-client-ca", 2)
+	newClientCA, newClientCAPEM := newTestCA(t, "new-client-ca", 3)
+
+	cert, key := serverCA.issue(t, "server", 10)
+	replaceFile(t, certFile, cert, -time.Hour)
+	replaceFile(t, keyFile, key, -time.Hour)
+	replaceFile(t, caFile, oldClientCAPEM, -time.Hour)
+
+	keyPair, err := newKeyPairReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientCAs, err := newCertPoolReloader(caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := newServerTLSConfig(keyPair, clientCAs)
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(serverCAPEM)
+
+	clientConfig := func(ca *testIssuer) *tls.Config {
+		certPEM, keyPEM := ca.issue(t, "cms", 20)
+		clientCert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the certificate is presented even if the server does not list its
+		// issuer as acceptable
+		return &tls.Config{
+			RootCAs:    roots,
+			ServerName: "localhost",
+			GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				return &clientCert, nil
+			},
+		}
+	}
+
+	if serial, err := handshake(t, config, clientConfig(oldClientCA)); err != nil || serial != 10 {
+		t.Fatalf("expected a handshake with certificate 10, got %d (%v)", serial, err)
+	}
+
+	cert, key = serverCA.issue(t, "server", 11)
+	replaceFile(t, certFile, cert, time.Minute)
+	replaceFile(t, keyFile, key, time.Minute)
+	replaceFile(t, caFile, newClientCAPEM, time.Minute)
+
+	keyPair.lastChecked = time.Now().Add(-reloadCheckInterval)
+	clientCAs.lastChecked = time.Now().Add(-reloadCheckInterval)
+
+	if _, err := handshake(t, config, clientConfig(oldClientCA)); err == nil {
+		t.Errorf("expected client certificates of the replaced CA to be rejected")
+	}
+
+	if serial, err := handshake(t, config, clientConfig(newClientCA)); err != nil || serial != 11 {
+		t.Errorf("expected a handshake with certificate 11, got %d (%v)", serial, err)
+	}
+}
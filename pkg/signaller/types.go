@@ -1,17 +1,41 @@
 package signaller
 
 import (
+	"bytes"
 	"net/http"
 	"sync"
 	"time"
 
+	"github.com/mittwald/kube-httpcache/pkg/logging"
 	"github.com/mittwald/kube-httpcache/pkg/watcher"
 )
 
+const (
+	// ModeHTTP re-sends incoming requests to the HTTP port of every frontend
+	ModeHTTP = "http"
+
+	// ModeAdmin translates incoming requests into "ban" commands issued
+	// over the Varnish admin port of every frontend
+	ModeAdmin = "admin"
+)
+
+var logger = logging.New("signaller")
+
 // Defines a http request object and the number of attempt
 type Signal struct {
-	Request *http.Request
-	Attempt int
+	Request  *http.Request
+	Attempt  int
+	Endpoint watcher.Endpoint
+	Ban      string
+
+	// Broadcast receives the result of the signal at position Index
+	Broadcast *Broadcast
+	Index     int
+
+	id uint64
+
+	// popped identifies the delivery attempt of a signal returned by Pop
+	popped uint64
 }
 
 type Signaller struct {
@@ -21,10 +45,68 @@ type Signaller struct {
 	MaxRetries     int
 	RetryBackoff   time.Duration
 	EndpointScheme string
-	endpoints      *watcher.EndpointConfig
-	signalQueue    chan Signal
-	errors         chan error
-	mutex          sync.RWMutex
+
+	// MaxRetryBackoff caps the exponentially growing delay between retries
+	MaxRetryBackoff time.Duration
+
+	Mode         string
+	AdminPort    int
+	TLSCertFile  string
+	TLSKeyFile   string
+	ClientCAFile string
+
+	EndpointCAFile     string
+	EndpointCertFile   string
+	EndpointKeyFile    string
+	EndpointServerName string
+
+	// Routing selects the frontends that signals for a URL are sent to (see
+	// RoutingAll, RoutingHash and RoutingShard); with RoutingShard, signals
+	// are sent to the first RoutingReplicas frontends on the hash ring
+	Routing         string
+	RoutingReplicas int
+
+	// TagHeader contains the cache tags (surrogate keys) of objects
+	TagHeader string
+
+	// JournalFile persists queued signals across restarts, if set
+	JournalFile string
+
+	Authenticators []Authenticator
+	Policy         *Policy
+
+	// RateLimiter throttles signal requests, if set
+	RateLimiter *RateLimiter
+
+	endpoints   *watcher.EndpointConfig
+	client      *http.Client
+	breaker     *circuitBreaker
+	history     *signalHistory
+	dedup       *deduplicator
+	batcher     *banBatcher
+	adminSecret []byte
+	queue       *signalQueue
+	errors      chan error
+	mutex       sync.RWMutex
+
+	// previousAdminSecret is tried if authentication with adminSecret
+	// fails, since frontends may not have picked up a rotated secret yet
+	previousAdminSecret []byte
+
+	// adminSessions holds one admin connection per frontend in admin mode
+	adminSessions      map[string]*adminSession
+	adminSessionsMutex sync.Mutex
+
+	broadcasts       map[string]*Broadcast
+	broadcastsPruned time.Time
+	broadcastsMutex  sync.Mutex
+
+	electionEnabled  bool
+	electionPending  bool
+	identity         string
+	leader           string
+	leaderMutex      sync.RWMutex
+	forwardTransport http.RoundTripper
 }
 
 func NewSignaller(
@@ -33,7 +115,19 @@ func NewSignaller(
 	workersCount int,
 	maxRetries int,
 	retryBackoff time.Duration,
+	queueCapacity int,
+	queueOverflow string,
+	breakerThreshold int,
+	breakerCooldown time.Duration,
+	replayTTL time.Duration,
+	dedupWindow time.Duration,
+	batchInterval time.Duration,
 ) *Signaller {
+	var batcher *banBatcher
+	if batchInterval > 0 {
+		batcher = newBanBatcher(batchInterval)
+	}
+
 	return &Signaller{
 		Address:        address,
 		Port:           port,
@@ -41,9 +135,18 @@ func NewSignaller(
 		MaxRetries:     maxRetries,
 		RetryBackoff:   retryBackoff,
 		EndpointScheme: "http",
+		Mode:           ModeHTTP,
+		Routing:        RoutingAll,
+		TagHeader:      "xkey",
 		endpoints:      watcher.NewEndpointConfig(),
-		signalQueue:    make(chan Signal),
+		client:         &http.Client{Timeout: signalTimeout},
+		breaker:        newCircuitBreaker(breakerThreshold, breakerCooldown),
+		history:        newSignalHistory(replayTTL),
+		dedup:          newDeduplicator(dedupWindow),
+		batcher:        batcher,
+		queue:          newSignalQueue(queueCapacity, queueOverflow),
 		errors:         make(chan error),
+		broadcasts:     make(map[string]*Broadcast),
 	}
 }
 
@@ -51,9 +154,36 @@ func (b *Signaller) GetErrors() chan error {
 	return b.errors
 }
 
+// SetEndpoints updates the frontends that signals are broadcast to. Recent
+// signals are replayed to new frontends, and pending signals to removed
+// frontends are dropped.
 func (b *Signaller) SetEndpoints(e *watcher.EndpointConfig) {
+	b.mutex.Lock()
+	previous := b.endpoints
+	b.endpoints = e
+	b.mutex.Unlock()
+
+	added, removed := diffEndpoints(previous.Endpoints, e.Endpoints)
+
+	if len(removed) > 0 {
+		b.forget(removed)
+	}
+
+	if len(added) > 0 {
+		b.replay(added, e.Endpoints)
+	}
+}
+
+// SetAdminSecret sets the secret used to authenticate against the Varnish
+// admin port of the frontends when running in admin mode; the previous
+// secret is kept for frontends that still use it
+func (b *Signaller) SetAdminSecret(secret []byte) {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
 
-	b.endpoints = e
+	if len(b.adminSecret) > 0 && !bytes.Equal(secret, b.adminSecret) {
+		b.previousAdminSecret = b.adminSecret
+	}
+
+	b.adminSecret = secret
 }
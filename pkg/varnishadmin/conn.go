@@ -0,0 +1,220 @@
+package varnishadmin
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// These constants define the usual response codes of the Varnish CLI
+const (
+	ResponseSyntaxError            = 100
+	ResponseUnknownCommand         = 101
+	ResponseUnimplemented          = 102
+	ResponseTooFewArguments        = 104
+	ResponseTooManyArguments       = 105
+	ResponseParams                 = 106
+	ResponseAuthenticationRequired = 107
+	ResponseOK                     = 200
+	ResponseCant                   = 300
+	ResponseComms                  = 400
+	ResponseClose                  = 500
+)
+
+// Error is returned when Varnish responds to a command with a status code
+// other than 200
+type Error struct {
+	Command string
+	Code    int
+	Body    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s failed (code %d): %s", e.Command, e.Code, e.Body)
+}
+
+// Conn is a connection to the Varnish admin port
+type Conn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	challenge string
+}
+
+// Dial connects to the Varnish admin port at address. This does not
+// perform authentication; use Authenticate for that.
+func Dial(ctx context.Context, address string) (*Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	c := Conn{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+
+	var code int
+	var hello []byte
+
+	err = c.withContext(ctx, func() error {
+		code, hello, err = readResponse(c.reader)
+		return err
+	})
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if code == ResponseAuthenticationRequired {
+		c.challenge = strings.Split(string(hello), "\n")[0]
+	}
+
+	return &c, nil
+}
+
+// Address returns the address of the admin port
+func (c *Conn) Address() string {
+	return c.conn.RemoteAddr().String()
+}
+
+// AuthenticationRequired tells if the connection needs to be authenticated
+// before issuing any other command
+func (c *Conn) AuthenticationRequired() bool {
+	return c.challenge != ""
+}
+
+// Authenticate answers the authentication challenge with the contents of
+// the Varnish secret file. It does nothing if no authentication is required.
+func (c *Conn) Authenticate(ctx context.Context, secret []byte) error {
+	if c.challenge == "" {
+		return nil
+	}
+
+	response := sha256.Sum256([]byte(c.challenge + "\n" + string(secret) + c.challenge + "\n"))
+
+	if _, err := c.Execute(ctx, "auth", hex.EncodeToString(response[:])); err != nil {
+		return err
+	}
+
+	c.challenge = ""
+	return nil
+}
+
+// Execute issues a command and returns the response body. The command is
+// sent verbatim, while every argument is quoted. Responses with a status
+// other than 200 are returned as *Error.
+func (c *Conn) Execute(ctx context.Context, command string, args ...string) ([]byte, error) {
+	if strings.ContainsAny(command, "\r\n") {
+		return nil, fmt.Errorf("command must not contain line breaks")
+	}
+
+	line := command
+	for _, a := range args {
+		line += " " + Quote(a)
+	}
+
+	var code int
+	var body []byte
+
+	err := c.withContext(ctx, func() error {
+		if _, err := io.WriteString(c.conn, line+"\n"); err != nil {
+			return err
+		}
+
+		var err error
+		code, body, err = readResponse(c.reader)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if code != ResponseOK {
+		return body, &Error{
+			Command: strings.SplitN(command, " ", 2)[0],
+			Code:    code,
+			Body:    strings.TrimSpace(string(body)),
+		}
+	}
+
+	return body, nil
+}
+
+// Close closes the connection
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// withContext runs f with the deadline of ctx applied to the connection,
+// and aborts pending I/O when ctx is cancelled
+func (c *Conn) withContext(ctx context.Context, f func() error) error {
+	deadline, _ := ctx.Deadline()
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = c.conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	err := f()
+	if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+		return ctxErr
+	}
+
+	return err
+}
+
+// readResponse reads a single response of the Varnish CLI protocol, which
+// consists of a 13 byte header ("CCC LLLLLLLL\n") followed by the body and
+// a trailing newline
+func readResponse(r *bufio.Reader) (int, []byte, error) {
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+
+	code, err := strconv.Atoi(string(header[0:3]))
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid response code: %s", err.Error())
+	}
+
+	length, err := strconv.Atoi(strings.TrimSpace(string(header[3:12])))
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid response length: %s", err.Error())
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r, int64(length)+1))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(body) != length+1 {
+		return 0, nil, fmt.Errorf("incomplete response body: %d of %d bytes read", len(body), length+1)
+	}
+
+	return code, body[:length], nil
+}
+
+// Quote quotes a string as a single argument of a CLI command
+func Quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`).Replace(s) + `"`
+}
@@ -0,0 +1,305 @@
+package varnishadmin
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testChallenge = "abcdefghijklmnopqrstuvwxyzabcdef"
+
+// fakeServer behaves like the Varnish admin port: it sends an
+// authentication challenge, closes the connection if authentication fails
+// and answers commands with the responses from its commands map (or 101)
+type fakeServer struct {
+	listener net.Listener
+	secret   string
+	commands map[string]string
+
+	accepted int
+	mutex    sync.Mutex
+}
+
+func newFakeServer(t *testing.T, secret string, commands map[string]string) *fakeServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeServer{listener: l, secret: secret, commands: commands}
+	go s.serve()
+
+	return s
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		s.accepted++
+		s.mutex.Unlock()
+
+		go s.handle(conn)
+	}
+}
+
+func respond(w *bufio.Writer, code int, body string) {
+	fmt.Fprintf(w, "%03d %-8d\n%s\n", code, len(body), body)
+	w.Flush()
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	respond(w, ResponseAuthenticationRequired, testChallenge+"\n\nAuthentication required.\n")
+
+	authenticated := false
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+
+		if !authenticated {
+			expected := sha256.Sum256([]byte(testChallenge + "\n" + s.secret + testChallenge + "\n"))
+			if line != `auth "`+hex.EncodeToString(expected[:])+`"` {
+				respond(w, ResponseClose, "Authentication failed.")
+				return
+			}
+
+			authenticated = true
+			respond(w, ResponseOK, "Authenticated.")
+			continue
+		}
+
+		if line == "quit" {
+			return
+		}
+
+		body, ok := s.commands[line]
+		if !ok {
+			respond(w, ResponseUnknownCommand, "Unknown request.")
+			continue
+		}
+
+		respond(w, ResponseOK, body)
+	}
+}
+
+func (s *fakeServer) Close() {
+	s.listener.Close()
+}
+
+func testContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 5*time.Second)
+}
+
+func TestReadResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		code     int
+		body     string
+		err      bool
+	}{
+		{"ok", "200 5       \nhello\n", 200, "hello", false},
+		{"empty body", "200 0       \n\n", 200, "", false},
+		{"multi-line body", "106 12      \nline1\nline2\n\n", 106, "line1\nline2\n", false},
+		{"invalid code", "2x0 5       \nhello\n", 0, "", true},
+		{"invalid length", "200 five    \nhello\n", 0, "", true},
+		{"incomplete body", "200 10      \nhello\n", 0, "", true},
+		{"incomplete header", "200 5", 0, "", true},
+	}
+
+	for _, test := range tests {
+		code, body, err := readResponse(bufio.NewReader(strings.NewReader(test.response)))
+		if (err != nil) != test.err {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+
+		if code != test.code || string(body) != test.body {
+			t.Errorf("%s: expected %d %q, got %d %q", test.name, test.code, test.body, code, string(body))
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		arg    string
+		quoted string
+	}{
+		{"simple", `"simple"`},
+		{`with "quotes"`, `"with \"quotes\""`},
+		{`back\slash`, `"back\\slash"`},
+		{"line\nbreak\r\ttab", `"line\nbreak\r\ttab"`},
+	}
+
+	for _, test := range tests {
+		if quoted := Quote(test.arg); quoted != test.quoted {
+			t.Errorf("expected %q to be quoted as %s, got %s", test.arg, test.quoted, quoted)
+		}
+	}
+}
+
+func TestConnAuthenticate(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		ok     bool
+	}{
+		{"correct secret", "secret\n", true},
+		{"wrong secret", "other\n", false},
+	}
+
+	s := newFakeServer(t, "secret\n", nil)
+	defer s.Close()
+
+	for _, test := range tests {
+		ctx, cancel := testContext()
+
+		conn, err := Dial(ctx, s.listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !conn.AuthenticationRequired() {
+			t.Errorf("%s: expected authentication to be required", test.name)
+		}
+
+		err = conn.Authenticate(ctx, []byte(test.secret))
+		if (err == nil) != test.ok {
+			t.Errorf("%s: unexpected result %v", test.name, err)
+		}
+
+		if adminErr, ok := err.(*Error); err != nil && (!ok || adminErr.Code != ResponseClose) {
+			t.Errorf("%s: expected an *Error with code %d, got %v", test.name, ResponseClose, err)
+		}
+
+		if test.ok && conn.AuthenticationRequired() {
+			t.Errorf("%s: expected no authentication to be required afterwards", test.name)
+		}
+
+		conn.Close()
+		cancel()
+	}
+}
+
+func TestConnExecute(t *testing.T) {
+	s := newFakeServer(t, "secret", map[string]string{
+		`ban "req.url" "~" "^/a b"`: "",
+		`vcl.list`:                  "active      auto/warm          0 boot",
+	})
+	defer s.Close()
+
+	ctx, cancel := testContext()
+	defer cancel()
+
+	conn, err := Dial(ctx, s.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.Authenticate(ctx, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		command string
+		args    []string
+		body    string
+		code    int
+	}{
+		{"ban", []string{"req.url", "~", "^/a b"}, "", ResponseOK},
+		{"vcl.list", nil, "active      auto/warm          0 boot", ResponseOK},
+		{"unknown", []string{"x"}, "Unknown request.", ResponseUnknownCommand},
+	}
+
+	for _, test := range tests {
+		body, err := conn.Execute(ctx, test.command, test.args...)
+		if string(body) != test.body {
+			t.Errorf("%s: expected body %q, got %q", test.command, test.body, string(body))
+		}
+
+		if test.code == ResponseOK {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", test.command, err)
+			}
+
+			continue
+		}
+
+		if adminErr, ok := err.(*Error); !ok || adminErr.Code != test.code || adminErr.Command != test.command {
+			t.Errorf("%s: expected an *Error with code %d, got %v", test.command, test.code, err)
+		}
+	}
+
+	if _, err := conn.Execute(ctx, "ban\nquit"); err == nil {
+		t.Errorf("expected commands with line breaks to be rejected")
+	}
+}
+
+func TestSessionReconnects(t *testing.T) {
+	s := newFakeServer(t, "secret", map[string]string{"ping": "PONG"})
+	defer s.Close()
+
+	session := NewSession(func(ctx context.Context) (*Conn, error) {
+		conn, err := Dial(ctx, s.listener.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+
+		if err := conn.Authenticate(ctx, []byte("secret")); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return conn, nil
+	})
+	defer session.Close()
+
+	ctx, cancel := testContext()
+	defer cancel()
+
+	if _, err := session.Execute(ctx, "ping"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the server closes the connection on "quit", which breaks it without
+	// the session noticing
+	if _, err := session.Execute(ctx, "quit"); err == nil {
+		t.Fatalf("expected the closed connection to be reported")
+	}
+
+	if _, err := session.Execute(ctx, "ping"); err != nil {
+		t.Fatalf("expected the session to reconnect, got %v", err)
+	}
+
+	if err := session.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	s.mutex.Lock()
+	accepted := s.accepted
+	s.mutex.Unlock()
+
+	if accepted != 2 {
+		t.Errorf("expected 2 connections, got %d", accepted)
+	}
+}
@@ -0,0 +1,144 @@
+package varnishadmin
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mittwald/kube-httpcache/pkg/logging"
+)
+
+var logger = logging.New("varnishadmin")
+
+// DialFunc establishes a new, authenticated admin connection
+type DialFunc func(ctx context.Context) (*Conn, error)
+
+// Session is a long-lived admin connection that is shared by everything
+// talking to the local Varnish admin port. The connection is established
+// on first use, health-checked while idle and re-established (including
+// authentication) when it breaks.
+type Session struct {
+	dial     DialFunc
+	conn     *Conn
+	lastUsed time.Time
+	mutex    sync.Mutex
+}
+
+// NewSession creates a session that uses dial for (re-)connecting
+func NewSession(dial DialFunc) *Session {
+	return &Session{dial: dial}
+}
+
+// Execute issues a command on the session's connection (see Conn.Execute),
+// connecting first if necessary. If the connection breaks, it is closed and
+// re-established on the next call; the command is not retried, since it
+// might already have taken effect.
+func (s *Session) Execute(ctx context.Context, command string, args ...string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.connect(ctx); err != nil {
+		return nil, err
+	}
+
+	body, err := s.conn.Execute(ctx, command, args...)
+	if err != nil {
+		if _, ok := err.(*Error); !ok {
+			s.disconnect()
+		}
+
+		return body, err
+	}
+
+	s.lastUsed = time.Now()
+	return body, nil
+}
+
+// Ping checks the session's connection, re-establishing it if necessary
+func (s *Session) Ping(ctx context.Context) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.ping(ctx)
+}
+
+// Run health-checks the connection in the given interval, and closes it
+// when ctx is cancelled
+func (s *Session) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			s.mutex.Lock()
+			if s.conn != nil && time.Since(s.lastUsed) >= interval {
+				if err := s.ping(ctx); err != nil && ctx.Err() == nil {
+					logger.Warning("admin connection health check failed", "error", err)
+				}
+			}
+			s.mutex.Unlock()
+
+		case <-ctx.Done():
+			s.Close()
+			return
+		}
+	}
+}
+
+// Close closes the session's connection; it will be re-established when the
+// session is used again
+func (s *Session) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.disconnect()
+}
+
+// ping checks the connection and reconnects once if it is broken. The
+// caller needs to hold the session's mutex.
+func (s *Session) ping(ctx context.Context) error {
+	if s.conn != nil {
+		if _, err := s.conn.Execute(ctx, "ping"); err == nil {
+			s.lastUsed = time.Now()
+			return nil
+		}
+
+		logger.Info("admin connection is broken; reconnecting", "endpoint", s.conn.Address())
+		s.disconnect()
+	}
+
+	if err := s.connect(ctx); err != nil {
+		return err
+	}
+
+	s.lastUsed = time.Now()
+	return nil
+}
+
+// connect establishes a connection if there is none. The caller needs to
+// hold the session's mutex.
+func (s *Session) connect(ctx context.Context) error {
+	if s.conn != nil {
+		return nil
+	}
+
+	conn, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.conn = conn
+	return nil
+}
+
+// disconnect closes the connection, if any. The caller needs to hold the
+// session's mutex.
+func (s *Session) disconnect() {
+	if s.conn == nil {
+		return
+	}
+
+	_ = s.conn.Close()
+	s.conn = nil
+}
@@ -3,8 +3,8 @@ package watcher
 import (
 	"time"
 
-	"github.com/golang/glog"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/watch"
@@ -21,6 +21,8 @@ func (v *EndpointWatcher) Run() (chan *EndpointConfig, chan error) {
 }
 
 func (v *EndpointWatcher) watch(updates chan *EndpointConfig, errors chan error) {
+	logger := logger.With("service", v.namespace+"/"+v.serviceName)
+
 	// indefinte for loop
 	for {
 		// watch k8s endpoint in certain namespace. with certain service name field.
@@ -29,8 +31,7 @@ func (v *EndpointWatcher) watch(updates chan *EndpointConfig, errors chan error)
 		})
 
 		if err != nil {
-			glog.Errorf("error while establishing watch: %s", err.Error())
-			glog.Infof("retrying after %s", v.retryBackoff.String())
+			logger.Error("error while establishing watch", "error", err, "retry_after", v.retryBackoff)
 
 			time.Sleep(v.retryBackoff)
 			continue
@@ -41,7 +42,7 @@ func (v *EndpointWatcher) watch(updates chan *EndpointConfig, errors chan error)
 		for ev := range c {
 			// ev.Type shows the status of watch(not clear)
 			if ev.Type == watch.Error {
-				glog.Warningf("error while watching: %+v", ev.Object)
+				logger.Warning("error while watching", "error", apierrors.FromObject(ev.Object))
 				continue
 			}
 
@@ -55,7 +56,7 @@ func (v *EndpointWatcher) watch(updates chan *EndpointConfig, errors chan error)
 			// if the length of endpoint is o or if there is no address in the endpoint object,
 			// shows the warning message and construct a new endpoint and assing it to v(receiver)
 			if len(endpoint.Subsets) == 0 || len(endpoint.Subsets[0].Addresses) == 0 {
-				glog.Warningf("service '%s' has no endpoints", v.serviceName)
+				logger.Warning("service has no endpoints")
 
 				v.endpointConfig = NewEndpointConfig()
 
@@ -64,7 +65,7 @@ func (v *EndpointWatcher) watch(updates chan *EndpointConfig, errors chan error)
 
 			// v is the current state, endpoint would be the previous state of endpoint
 			if v.endpointConfig.Endpoints.EqualsEndpoints(endpoint.Subsets[0]) {
-				glog.Infof("endpoints did not change")
+				logger.Debug("endpoints did not change")
 				continue
 			}
 
@@ -76,12 +77,12 @@ func (v *EndpointWatcher) watch(updates chan *EndpointConfig, errors chan error)
 				po, err := v.client.CoreV1().Pods(v.namespace).Get(a.TargetRef.Name, metav1.GetOptions{})
 
 				if err != nil {
-					glog.Errorf("error while locating endpoint : %s", err.Error())
+					logger.Error("error while locating endpoint", "endpoint", a.IP, "pod", a.TargetRef.Name, "error", err)
 					continue
 				}
 
 				if len(po.Status.Conditions) > 0 && po.Status.Conditions[0].Status != v1.ConditionTrue {
-					glog.Infof("skipping endpoint (not healthy): %s", puid)
+					logger.Info("skipping endpoint (not healthy)", "endpoint", a.IP, "pod", a.TargetRef.Name, "uid", puid)
 					continue
 				}
 
@@ -91,7 +92,7 @@ func (v *EndpointWatcher) watch(updates chan *EndpointConfig, errors chan error)
 
 			// if there nothing in the addresses list, construct a new endpoint using NewEndpointConfig() function
 			if len(addresses) == 0 {
-				glog.Warningf("service '%s' has no endpoint that is ready", v.serviceName)
+				logger.Warning("service has no endpoint that is ready")
 				v.endpointConfig = NewEndpointConfig()
 				continue
 			}
@@ -104,7 +105,7 @@ func (v *EndpointWatcher) watch(updates chan *EndpointConfig, errors chan error)
 			// not sure why is it a BackendList, possibly due the the things been done to the endpoint variable in advance
 			newBackendList, err := EndpointListFromSubset(endpoint.Subsets[0], v.portName)
 			if err != nil {
-				glog.Errorf("error while building backend list: %s", err.Error())
+				logger.Error("error while building backend list", "error", err)
 				continue
 			}
 
@@ -121,6 +122,6 @@ func (v *EndpointWatcher) watch(updates chan *EndpointConfig, errors chan error)
 			updates <- newConfig
 		}
 
-		glog.V(5).Info("watch has ended. starting new watch")
+		logger.Debug("watch has ended; starting new watch")
 	}
 }
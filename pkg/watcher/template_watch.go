@@ -1,7 +1,6 @@
 package watcher
 
 import (
-	"github.com/golang/glog"
 	"io/ioutil"
 )
 
@@ -17,11 +16,11 @@ func (t *fsnotifyTemplateWatcher) Run() (chan []byte, chan error) {
 // a function to watch filesystem change, then read that file and push it to updates channel
 func (t *fsnotifyTemplateWatcher) watch(updates chan []byte, errors chan error) {
 	for ev := range t.watcher.Events {
-		glog.V(6).Infof("observed %s event on %s", ev.String(), ev.Name)
+		logger.Debug("observed file event", "event", ev.Op.String(), "file", ev.Name)
 
 		content, err := ioutil.ReadFile(t.filename)
 		if err != nil {
-			glog.Warningf("error while reading file %s: %s", t.filename, err.Error())
+			logger.Warning("error while reading file", "file", t.filename, "error", err)
 
 			errors <- err
 			continue
@@ -1,7 +1,6 @@
 package watcher
 
 import (
-	"github.com/golang/glog"
 	"io/ioutil"
 	"os"
 	"time"
@@ -34,13 +33,13 @@ func (t *pollingTemplateWatcher) watch(updates chan []byte, errors chan error) {
 		}
 
 		if stat.ModTime() != t.lastObservedTimestamp {
-			glog.V(6).Infof("observed new modification time on %s", t.filename)
+			logger.Debug("observed new modification time", "file", t.filename)
 
 			t.lastObservedTimestamp = stat.ModTime()
 
 			content, err := ioutil.ReadFile(t.filename)
 			if err != nil {
-				glog.Warningf("error while reading file %s: %s", t.filename, err.Error())
+				logger.Warning("error while reading file", "file", t.filename, "error", err)
 
 				errors <- err
 				continue
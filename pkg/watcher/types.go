@@ -4,10 +4,13 @@ import (
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/mittwald/kube-httpcache/pkg/logging"
 
 	"k8s.io/client-go/kubernetes"
 )
 
+var logger = logging.New("watcher")
+
 // Endpoint include name host(IP) port and probe
 // EndpointConfig includes the list of endpoints and a primary endpoint out of that
 type EndpointConfig struct {